@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/noahcampbell/s3dropbox/formgen"
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/source"
+)
+
+/*
+runForm signs a policy and writes the browser upload form for it, as an HTML
+page or as JSON for pages that build the form themselves.
+*/
+func runForm(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	o := &options{}
+	flags := flag.NewFlagSet("s3dropbox form", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "html", "output an html page or a json description of the form")
+	htmlTemplate := flags.String("html-template", "", "render the form with this html/template `file` instead of the default page")
+	key := flags.String("key", "", "value of the key field (default the $key condition, with ${filename} after a prefix)")
+	title := flags.String("title", "", "title of the page")
+	signingFlags(flags, o)
+	endpointFlags(flags, o)
+	flags.StringVar(&o.output, "output", "", "write the form to this file instead of stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage:\n")
+		fmt.Fprintf(stderr, "  s3dropbox form [--format html|json] [--html-template <file>] <policy>\n\n")
+		fmt.Fprintf(stderr, "The policy may be a file, http(s) URL, - for stdin or data: URI.\n\n")
+		flags.PrintDefaults()
+	}
+	if ok := flags.Parse(args); ok != nil {
+		if ok == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	switch {
+	case flags.NArg() != 1:
+		return usageError(stderr, flags, "Exactly one policy is required.")
+	case *format != "html" && *format != "json":
+		return usageError(stderr, flags, fmt.Sprintf("Unknown format %q.", *format))
+	case *format == "json" && *htmlTemplate != "":
+		return usageError(stderr, flags, "--html-template can not be combined with --format json.")
+	}
+
+	var tmpl *template.Template
+	if *htmlTemplate != "" {
+		var ok error
+		if tmpl, ok = template.New(filepath.Base(*htmlTemplate)).ParseFiles(*htmlTemplate); ok != nil {
+			return failure(stderr, ok)
+		}
+	}
+
+	resolver := &source.Resolver{Stdin: stdin}
+	raw, ok := resolver.Fetch(context.Background(), flags.Arg(0))
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	p, ok := policy.ParsePolicy(raw)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	creds, ok := o.retrieveCredentials()
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+
+	opts := []formgen.Option{formgen.WithTitle(*title), formgen.WithTransportOptions(o.formOptions()...)}
+	if *key != "" {
+		opts = append(opts, formgen.WithKey(*key))
+	}
+	form, ok := formgen.New(p, creds, opts...)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+
+	var out bytes.Buffer
+	if *format == "json" {
+		encoder := json.NewEncoder(&out)
+		encoder.SetIndent("", "  ")
+		ok = encoder.Encode(form)
+	} else {
+		ok = form.Render(&out, tmpl)
+	}
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	if o.output != "" {
+		return failure(stderr, os.WriteFile(o.output, out.Bytes(), 0644))
+	}
+	_, ok = stdout.Write(out.Bytes())
+	return failure(stderr, ok)
+}
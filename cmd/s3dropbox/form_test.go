@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/noahcampbell/s3dropbox/formgen"
+)
+
+func TestForm(t *testing.T) {
+	file := writePolicy(t, `{"bucket": "my-s3dropbox"}, ["starts-with", "$key", "user/eric/"], ["content-length-range", 1, 10]`)
+	code, stdout, stderr := runCommand("form", "--title", "Upload", "--aws-secret-key-id=id", "--aws-secret-key=secret", file)
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	for _, expected := range []string{`action="https://my-s3dropbox.s3.amazonaws.com/"`, `name="key" value="user/eric/${filename}"`,
+		`name="AWSAccessKeyId" value="id"`, `name="signature"`, `<title>Upload</title>`} {
+		if !strings.Contains(stdout, expected) {
+			t.Errorf("Expected %s in the form: %s", expected, stdout)
+		}
+	}
+}
+
+func TestFormJSON(t *testing.T) {
+	file := writePolicy(t, `{"bucket": "my-s3dropbox"}, ["starts-with", "$key", "user/eric/"]`)
+	code, stdout, stderr := runCommand("form", "--format", "json", "--signature-version", "4", "--region", "eu-west-1",
+		"--path-style", "--key", "user/eric/report.txt", "--aws-secret-key-id=id", "--aws-secret-key=secret", file)
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	var form formgen.Form
+	if ok := json.Unmarshal([]byte(stdout), &form); ok != nil {
+		t.Fatalf("Invalid JSON %q: %s", stdout, ok)
+	}
+	if form.Action != "https://s3.eu-west-1.amazonaws.com/my-s3dropbox/" || form.Fields[0].Value != "user/eric/report.txt" ||
+		form.Fields[len(form.Fields)-1].Name != "x-amz-signature" {
+		t.Errorf("Unexpected form: %+v", form)
+	}
+}
+
+func TestFormTemplate(t *testing.T) {
+	file := writePolicy(t, `{"bucket": "my-s3dropbox"}, ["starts-with", "$key", "user/eric/"]`)
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "form.html")
+	os.WriteFile(tmpl, []byte(`<form action="{{.Action}}">{{range .Fields}}<input name="{{.Name}}">{{end}}</form>`), 0644)
+	output := filepath.Join(dir, "upload.html")
+	code, _, stderr := runCommand("form", "--html-template", tmpl, "--output", output, "--aws-secret-key-id=id", "--aws-secret-key=secret", file)
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	page, _ := os.ReadFile(output)
+	if !strings.HasPrefix(string(page), `<form action="https://my-s3dropbox.s3.amazonaws.com/"><input name="key">`) {
+		t.Errorf("Unexpected page: %s", page)
+	}
+}
+
+func TestDegenerateForm(t *testing.T) {
+	file := writePolicy(t, `{"bucket": "my-s3dropbox"}, ["starts-with", "$key", "user/eric/"]`)
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"form"}, exitUsage},
+		{[]string{"form", "--format", "xml", file}, exitUsage},
+		{[]string{"form", "--format", "json", "--html-template", "form.html", file}, exitUsage},
+		{[]string{"form", "--html-template", "/nonexistent/form.html", file}, exitFailure},
+		{[]string{"form", "--signature-version", "3", "--aws-secret-key-id=id", "--aws-secret-key=secret", file}, exitFailure},
+		{[]string{"form", file}, exitFailure},
+	} {
+		clearCredentials(t)
+		if code, _, _ := runCommand(test.args...); code != test.code {
+			t.Errorf("%v: Expected exit code %d, got %d", test.args, test.code, code)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+/*
+s3dropbox uploads files to S3 using the AWS Form Upload technique and
+creates the policy documents those uploads are signed with.
+
+Upload a file using an existing policy
+
+	s3dropbox --policy ./upload.policy file1.ext
+
+The policy may also be an http:// or https:// URL, - for standard input or
+a data: URI.
+
+Create an upload policy document
+
+	s3dropbox --expiration 2023-12-31T23:59:59.000Z --condition acl=private \
+		--condition-startswith '$key=user/upload' --condition bucket=my-s3dropbox \
+		--condition-range '$content-length,1024,2048' \
+		--aws-secret-key-id=id --aws-secret-key=secret --output upload.policy
+
+Create a policy from presets or a YAML or JSON template, valid for 15 minutes
+
+	s3dropbox policy create --preset user-upload-prefix --preset max-10MB \
+		--var bucket=my-s3dropbox --var user=eric --expires-in 15m
+
+Check a policy document for mistakes and overly broad conditions
+
+	s3dropbox policy lint upload.policy
+
+Generate an HTML upload form for a policy, or its fields as JSON
+
+	s3dropbox form --title "Holiday photos" upload.policy > upload.html
+	s3dropbox form --format json upload.policy
+
+Sign policies for browser uploads over HTTP, for the callers of a JSON
+configuration
+
+	s3dropbox serve --config signers.json --addr :8080
+*/
+package main
+
+import (
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
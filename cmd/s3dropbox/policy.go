@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/formscrape"
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/source"
+)
+
+/*
+runPolicy dispatches s3dropbox policy <command>.
+*/
+func runPolicy(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	usage := func() {
+		fmt.Fprintf(stderr, "Usage:\n")
+		fmt.Fprintf(stderr, "  s3dropbox policy create [--preset <name>] [--template <file>] [--var name=value] [--expires-in 15m]\n")
+		fmt.Fprintf(stderr, "  s3dropbox policy lint [--format text|json] <policy>\n")
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, "s3dropbox: A policy command is required.\n")
+		usage()
+		return exitUsage
+	}
+	switch args[0] {
+	case "create":
+		return createFromTemplates(args[1:], stdin, stdout, stderr)
+	case "lint":
+		return lintPolicy(args[1:], stdin, stdout, stderr)
+	case "-h", "-help", "--help", "help":
+		usage()
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "s3dropbox: Unknown policy command %q.\n", args[0])
+		usage()
+		return exitUsage
+	}
+}
+
+/*
+lintPolicy reports the diagnostics of policy.Lint and fails when any is an
+error.
+*/
+func lintPolicy(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("s3dropbox policy lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "text", "diagnostics as text, one per line, or a json array")
+	formIndex := flags.Int("form-index", 0, "upload form to lint when the policy is a web page with several")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage:\n")
+		fmt.Fprintf(stderr, "  s3dropbox policy lint [--format text|json] <policy>\n\n")
+		fmt.Fprintf(stderr, "The policy may be a file, http(s) URL, web page with an upload form, - for stdin or data: URI.\n\n")
+		flags.PrintDefaults()
+	}
+	if ok := flags.Parse(args); ok != nil {
+		if ok == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		return usageError(stderr, flags, "Exactly one policy to lint is required.")
+	}
+	if *format != "text" && *format != "json" {
+		return usageError(stderr, flags, fmt.Sprintf("Unknown format %q.", *format))
+	}
+
+	location := flags.Arg(0)
+	resolver := &source.Resolver{Stdin: stdin}
+	raw, ok := resolver.Fetch(context.Background(), location)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	if formscrape.IsHTML(raw) {
+		form, ok := scrapeForm(raw, location, *formIndex)
+		if ok != nil {
+			return failure(stderr, ok)
+		}
+		raw = form.RawPolicy
+	}
+
+	diagnostics := policy.Lint(raw, time.Now())
+	if *format == "json" {
+		if diagnostics == nil {
+			diagnostics = []policy.Diagnostic{}
+		}
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if ok = encoder.Encode(diagnostics); ok != nil {
+			return failure(stderr, ok)
+		}
+	} else {
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintf(stdout, "%s: %s\n", location, diagnostic)
+		}
+		if len(diagnostics) == 0 {
+			fmt.Fprintf(stdout, "%s: no problems found\n", location)
+		}
+	}
+
+	if policy.HasErrors(diagnostics) {
+		return exitFailure
+	}
+	return exitOK
+}
+
+/*
+createFromTemplates builds a policy from presets, template files and
+--condition flags, checks it with policy.Lint and signs it.
+*/
+func createFromTemplates(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	o := &options{}
+	var presets, templates, variables conditionList
+	flags := flag.NewFlagSet("s3dropbox policy create", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Var(&presets, "preset", fmt.Sprintf("built in template `name`: %s (repeatable)", strings.Join(policy.PresetNames(), ", ")))
+	flags.Var(&templates, "template", "JSON or YAML template `file`, http(s) URL, - for stdin or data: URI (repeatable)")
+	flags.Var(&variables, "var", "template variable `name=value` (repeatable)")
+	expiresIn := flags.String("expires-in", "", "the policy expires after this `duration`, e.g. 15m, 2h or 7d (default the templates' expires_in or 1h)")
+	flags.StringVar(&o.expiration, "expiration", "", "expire the policy at this time instead, e.g. 2023-12-31T23:59:59.000Z")
+	conditionFlags(flags, o)
+	signingFlags(flags, o)
+	flags.StringVar(&o.output, "output", "", "write the policy document to this file instead of stdout")
+	format := flags.String("format", "text", "output the document, policy and signature as text or a json object")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage:\n")
+		fmt.Fprintf(stderr, "  s3dropbox policy create [--preset <name>] [--template <file>] [--var name=value] [--expires-in 15m]\n\n")
+		flags.PrintDefaults()
+	}
+	if ok := flags.Parse(args); ok != nil {
+		if ok == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	switch {
+	case flags.NArg() != 0:
+		return usageError(stderr, flags, "Unexpected arguments when creating a policy.")
+	case len(presets)+len(templates)+len(o.conditions)+len(o.conditionStartsWith)+len(o.conditionRange) == 0:
+		return usageError(stderr, flags, "At least one --preset, --template or --condition is required.")
+	case *expiresIn != "" && o.expiration != "":
+		return usageError(stderr, flags, "--expires-in and --expiration can not be combined.")
+	case *format != "text" && *format != "json":
+		return usageError(stderr, flags, fmt.Sprintf("Unknown format %q.", *format))
+	}
+
+	var loaded []*policy.Template
+	for _, name := range presets {
+		template, ok := policy.Preset(name)
+		if ok != nil {
+			return failure(stderr, ok)
+		}
+		loaded = append(loaded, template)
+	}
+	resolver := &source.Resolver{Stdin: stdin}
+	for _, location := range templates {
+		doc, ok := resolver.Fetch(context.Background(), location)
+		if ok != nil {
+			return failure(stderr, ok)
+		}
+		template, ok := policy.ParseTemplate(doc)
+		if ok != nil {
+			return failure(stderr, fmt.Errorf("%s: %s", location, ok))
+		}
+		loaded = append(loaded, template)
+	}
+	template := policy.CombineTemplates(loaded...)
+
+	values := map[string]string{}
+	for _, variable := range variables {
+		name, value, found := strings.Cut(variable, "=")
+		if !found || name == "" {
+			return usageError(stderr, flags, fmt.Sprintf("Invalid variable %q.  Expected name=value.", variable))
+		}
+		values[name] = value
+	}
+
+	now := time.Now()
+	expiration := template.Expiration(now)
+	switch {
+	case *expiresIn != "":
+		duration, ok := policy.ParseExpiresIn(*expiresIn)
+		if ok != nil {
+			return failure(stderr, ok)
+		}
+		expiration = now.Add(duration)
+	case o.expiration != "":
+		var ok error
+		if expiration, ok = time.Parse(time.RFC3339, o.expiration); ok != nil {
+			return failure(stderr, fmt.Errorf("Invalid expiration %q: %s", o.expiration, ok))
+		}
+	}
+
+	p, ok := template.Policy(expiration, values)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	if ok = addConditions(p, o); ok != nil {
+		return failure(stderr, ok)
+	}
+	doc, ok := p.Canonical()
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	diagnostics := policy.Lint(doc, now)
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintf(stderr, "s3dropbox: %s\n", diagnostic)
+	}
+	if policy.HasErrors(diagnostics) {
+		return exitFailure
+	}
+
+	signed, ok := signPolicy(o, p)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	if *format == "json" {
+		if o.output != "" {
+			if ok = os.WriteFile(o.output, append(signed.Document, '\n'), 0644); ok != nil {
+				return failure(stderr, ok)
+			}
+		}
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		return failure(stderr, encoder.Encode(signed))
+	}
+	return failure(stderr, writeSignedPolicy(o.output, signed, stdout))
+}
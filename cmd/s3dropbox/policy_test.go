@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+func writePolicy(t *testing.T, conditions string) string {
+	file := filepath.Join(t.TempDir(), "upload.policy")
+	expiration := time.Now().Add(time.Hour).UTC().Format(policy.ExpirationFormat)
+	os.WriteFile(file, []byte(`{"expiration": "`+expiration+`", "conditions": [`+conditions+`]}`), 0644)
+	return file
+}
+
+func TestPolicyLint(t *testing.T) {
+	file := writePolicy(t, `{"bucket": "b"}, ["starts-with", "$key", "up/"], {"acl": "public-read"}`)
+	code, stdout, stderr := runCommand("policy", "lint", file)
+	if code != exitOK {
+		t.Errorf("Warnings should not fail, got exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, file+": $.conditions: warning: Missing content-length-range.") ||
+		!strings.Contains(stdout, file+": $.conditions[2].acl: warning: ") {
+		t.Errorf("Expected the warnings, got: %s", stdout)
+	}
+}
+
+func TestPolicyLintJSON(t *testing.T) {
+	file := writePolicy(t, `{"bucket": "b"}, ["starts-with", "key", "up/"], ["content-length-range", 1, 10]`)
+	code, stdout, _ := runCommand("policy", "lint", "--format", "json", file)
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	var diagnostics []policy.Diagnostic
+	if ok := json.Unmarshal([]byte(stdout), &diagnostics); ok != nil {
+		t.Fatalf("Invalid JSON %q: %s", stdout, ok)
+	}
+	if len(diagnostics) != 2 || diagnostics[0].Path != "$.conditions[1][1]" || diagnostics[0].Severity != policy.SeverityError {
+		t.Errorf("Unexpected diagnostics: %+v", diagnostics)
+	}
+}
+
+func TestPolicyLintClean(t *testing.T) {
+	file := writePolicy(t, `{"bucket": "b"}, ["starts-with", "$key", "up/"], ["content-length-range", 1, 10]`)
+	if code, stdout, _ := runCommand("policy", "lint", file); code != exitOK || !strings.Contains(stdout, "no problems found") {
+		t.Errorf("Expected a clean policy, got %d: %s", code, stdout)
+	}
+}
+
+func TestDegeneratePolicyCommand(t *testing.T) {
+	for _, args := range [][]string{{"policy"}, {"policy", "frobnicate"}, {"policy", "lint"}, {"policy", "lint", "--format", "xml", "p"}} {
+		if code, _, _ := runCommand(args...); code != exitUsage {
+			t.Errorf("%v: Expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
+
+func TestPolicyCreatePresets(t *testing.T) {
+	code, stdout, stderr := runCommand("policy", "create", "--preset", "user-upload-prefix", "--preset", "images-only", "--preset", "max-10MB",
+		"--var", "bucket=my-s3dropbox", "--var", "user=eric", "--expires-in", "15m", "--aws-secret-key-id=id", "--aws-secret-key=secret")
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "policy: ") || !strings.HasPrefix(lines[2], "signature: ") {
+		t.Fatalf("Expected the document, policy and signature, got: %s", stdout)
+	}
+	p, ok := policy.ParsePolicy([]byte(lines[0]))
+	if ok != nil {
+		t.Fatalf("Unable to parse the generated policy: %s", ok)
+	}
+	if !p.ConditionMatches("bucket", "my-s3dropbox") || !p.ConditionMatches("$key", "uploads/eric/photo.jpg") || !p.ConditionMatches("$Content-Type", "image/png") {
+		t.Errorf("Unexpected policy: %s", lines[0])
+	}
+	if remaining := time.Until(p.Expiration); remaining < 14*time.Minute || remaining > 15*time.Minute {
+		t.Errorf("Expected the policy to expire in 15 minutes, got %s", p.Expiration)
+	}
+}
+
+func TestPolicyCreateTemplateJSON(t *testing.T) {
+	template := filepath.Join(t.TempDir(), "upload.yaml")
+	os.WriteFile(template, []byte("expires_in: 2h\nconditions:\n  - bucket: ${bucket}\n  - [starts-with, $key, reports/]\n  - [content-length-range, 1, 1MB]\n"), 0644)
+	code, stdout, stderr := runCommand("policy", "create", "--template", template, "--var", "bucket=b", "--condition", "acl=private",
+		"--signature-version", "4", "--region", "eu-west-1", "--format", "json", "--aws-secret-key-id=id", "--aws-secret-key=secret")
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	var signed struct {
+		Document  json.RawMessage
+		Policy    string
+		Signature string
+		Fields    map[string]string
+	}
+	if ok := json.Unmarshal([]byte(stdout), &signed); ok != nil {
+		t.Fatalf("Invalid JSON %q: %s", stdout, ok)
+	}
+	p, ok := policy.ParsePolicy(signed.Document)
+	if ok != nil {
+		t.Fatalf("Unable to parse the generated policy: %s", ok)
+	}
+	if !p.ConditionMatches("acl", "private") || !p.ConditionMatches("x-amz-algorithm", policy.SigV4Algorithm) {
+		t.Errorf("Unexpected policy: %s", signed.Document)
+	}
+	if signed.Fields["x-amz-signature"] != signed.Signature || signed.Fields["policy"] != signed.Policy || !strings.HasPrefix(signed.Fields["x-amz-credential"], "id/") {
+		t.Errorf("Unexpected fields: %v", signed.Fields)
+	}
+}
+
+func TestDegeneratePolicyCreate(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"policy", "create"}, exitUsage},
+		{[]string{"policy", "create", "--preset", "max-10MB", "--expires-in", "1h", "--expiration", "2023-12-31T23:59:59.000Z"}, exitUsage},
+		{[]string{"policy", "create", "--preset", "everything"}, exitFailure},
+		{[]string{"policy", "create", "--preset", "user-upload-prefix", "--var", "bucket=b"}, exitFailure},
+		{[]string{"policy", "create", "--preset", "max-10MB", "--aws-secret-key-id=id", "--aws-secret-key=secret"}, exitFailure},
+		{[]string{"policy", "create", "--preset", "max-10MB", "--expires-in", "soon"}, exitFailure},
+	} {
+		if code, _, _ := runCommand(test.args...); code != test.code {
+			t.Errorf("%v: Expected exit code %d, got %d", test.args, test.code, code)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+const (
+	progressAuto = "auto"
+	progressBar  = "bar"
+	progressJSON = "json"
+	progressNone = "none"
+
+	progressBarWidth = 30
+)
+
+/*
+progressStatus is a snapshot of an upload.  In --progress json mode each one
+is written as a line of JSON.
+*/
+type progressStatus struct {
+	File           string  `json:"file"`
+	Sent           int64   `json:"sent"`
+	Total          int64   `json:"total"`
+	Percent        float64 `json:"percent"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	ETASeconds     float64 `json:"eta_seconds"`
+	Done           bool    `json:"done"`
+}
+
+/*
+progressMeter turns the byte counts from the uploader into throttled
+progressStatus reports.
+*/
+type progressMeter struct {
+	mu       sync.Mutex
+	file     string
+	now      func() time.Time
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+	done     bool
+	report   func(status progressStatus)
+}
+
+/*
+newProgress returns the transport.ProgressFunc rendering mode to out, or nil
+when no progress should be shown.  auto shows a progress bar when out is a
+terminal.
+*/
+func newProgress(mode, file string, out io.Writer) (progress transport.ProgressFunc, ok error) {
+	meter := &progressMeter{file: file, now: time.Now}
+	switch mode {
+	case progressAuto:
+		if !isTerminal(out) {
+			return nil, nil
+		}
+		fallthrough
+	case progressBar:
+		meter.interval = 100 * time.Millisecond
+		meter.report = func(status progressStatus) { renderBar(out, status) }
+	case progressJSON:
+		meter.interval = time.Second
+		encoder := json.NewEncoder(out)
+		meter.report = func(status progressStatus) { encoder.Encode(status) }
+	case progressNone:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Invalid --progress %q.  Expected auto, bar, json or none.", mode)
+	}
+	return meter.update, nil
+}
+
+func isTerminal(out io.Writer) bool {
+	file, isFile := out.(*os.File)
+	if !isFile {
+		return false
+	}
+	info, ok := file.Stat()
+	return ok == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+/*
+update reports at most once per interval, but always reports the end of the
+upload, once.
+*/
+func (m *progressMeter) update(sent, total int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if m.start.IsZero() {
+		m.start = now
+	}
+	done := sent >= total
+	if done && m.done || !done && !m.last.IsZero() && now.Sub(m.last) < m.interval {
+		return
+	}
+	m.last = now
+	m.done = done
+
+	status := progressStatus{File: m.file, Sent: sent, Total: total, Done: done}
+	elapsed := now.Sub(m.start).Seconds()
+	status.ElapsedSeconds = elapsed
+	if total > 0 {
+		status.Percent = float64(sent) * 100 / float64(total)
+	}
+	if elapsed > 0 {
+		status.BytesPerSecond = float64(sent) / elapsed
+	}
+	if status.BytesPerSecond > 0 {
+		status.ETASeconds = float64(total-sent) / status.BytesPerSecond
+	}
+	m.report(status)
+}
+
+/*
+renderBar redraws a single line progress bar, e.g.
+
+	[=============>                ]  45%  12.3 MiB / 27.0 MiB  3.1 MiB/s  ETA 0:04
+*/
+func renderBar(out io.Writer, status progressStatus) {
+	filled := int(status.Percent * progressBarWidth / 100)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	eta := "ETA " + formatSeconds(status.ETASeconds)
+	if status.Done {
+		eta = "in " + formatSeconds(status.ElapsedSeconds)
+	}
+	fmt.Fprintf(out, "\r[%s] %3.0f%%  %s / %s  %s/s  %s", bar, status.Percent,
+		formatBytes(float64(status.Sent)), formatBytes(float64(status.Total)), formatBytes(status.BytesPerSecond), eta)
+	if status.Done {
+		fmt.Fprintln(out)
+	}
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
+
+func formatSeconds(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	if d >= time.Hour {
+		return fmt.Sprintf("%d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+	}
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+/*
+batchProgress combines the progress of the files in a batch into a single
+report.  Until a file starts its size on disk stands in for the length of
+its request.
+*/
+type batchProgress struct {
+	mu       sync.Mutex
+	sent     map[string]int64
+	total    map[string]int64
+	progress transport.ProgressFunc
+}
+
+func newBatchProgress(files []transport.BatchFile, progress transport.ProgressFunc) *batchProgress {
+	b := &batchProgress{sent: map[string]int64{}, total: map[string]int64{}, progress: progress}
+	for _, file := range files {
+		b.total[file.Name] = file.Size
+	}
+	return b
+}
+
+func (b *batchProgress) update(file transport.BatchFile, sent, total int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent[file.Name] = sent
+	b.total[file.Name] = total
+	b.report()
+}
+
+/*
+finish counts a file as sent, even when it failed, so the report reaches its
+total once every file is done.
+*/
+func (b *batchProgress) finish(file transport.BatchFile) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent[file.Name] = b.total[file.Name]
+	b.report()
+}
+
+func (b *batchProgress) report() {
+	var sent, total int64
+	for name, size := range b.total {
+		sent += b.sent[name]
+		total += size
+	}
+	b.progress(sent, total)
+}
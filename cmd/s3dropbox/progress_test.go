@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+/*
+fakeClock advances by step every time it is read.
+*/
+func fakeClock(step time.Duration) func() time.Time {
+	now := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	return func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	var out bytes.Buffer
+	meter := &progressMeter{now: fakeClock(time.Second), interval: 100 * time.Millisecond,
+		report: func(status progressStatus) { renderBar(&out, status) }}
+	meter.update(0, 4<<20)
+	meter.update(1<<20, 4<<20)
+	meter.update(4<<20, 4<<20)
+
+	lines := strings.Split(out.String(), "\r")
+	if len(lines) != 4 {
+		t.Fatalf("Expected three redraws of the bar, got: %q", out.String())
+	}
+	if expected := "[=======>                      ]  25%  1.0 MiB / 4.0 MiB  1.0 MiB/s  ETA 0:03"; lines[2] != expected {
+		t.Errorf("Unexpected bar.\nExpected: %q\nActual:   %q", expected, lines[2])
+	}
+	if !strings.HasPrefix(lines[3], "[==============================] 100%") || !strings.HasSuffix(lines[3], "in 0:02\n") {
+		t.Errorf("Unexpected final bar: %q", lines[3])
+	}
+}
+
+func TestProgressJSONLines(t *testing.T) {
+	var out bytes.Buffer
+	progress, ok := newProgress(progressJSON, "artifact.tgz", &out)
+	if ok != nil {
+		t.Fatalf("Unable to create progress: %s", ok)
+	}
+	progress(512, 1024)
+	progress(768, 1024)
+	progress(1024, 1024)
+
+	var statuses []progressStatus
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var status progressStatus
+		if ok := json.Unmarshal(scanner.Bytes(), &status); ok != nil {
+			t.Fatalf("Invalid JSON line %q: %s", scanner.Text(), ok)
+		}
+		statuses = append(statuses, status)
+	}
+	// The second update falls within the one second interval.
+	if len(statuses) != 2 {
+		t.Fatalf("Expected the first and final updates, got: %s", out.String())
+	}
+	if last := statuses[1]; !last.Done || last.Sent != 1024 || last.Percent != 100 || last.File != "artifact.tgz" {
+		t.Errorf("Unexpected final status: %+v", last)
+	}
+}
+
+func TestProgressAutoWithoutTerminal(t *testing.T) {
+	if progress, _ := newProgress(progressAuto, "f", &bytes.Buffer{}); progress != nil {
+		t.Errorf("No progress should be shown when stderr is not a terminal")
+	}
+}
+
+func TestDegenerateProgressMode(t *testing.T) {
+	if _, ok := newProgress("fancy", "f", &bytes.Buffer{}); ok == nil {
+		t.Errorf("An unknown --progress mode should be an error")
+	}
+}
+
+func TestBatchProgress(t *testing.T) {
+	var reports [][2]int64
+	files := []transport.BatchFile{{Name: "a", Size: 100}, {Name: "b", Size: 200}}
+	batch := newBatchProgress(files, func(sent, total int64) {
+		reports = append(reports, [2]int64{sent, total})
+	})
+	batch.update(files[0], 50, 150)
+	batch.update(files[1], 100, 250)
+	batch.finish(files[0])
+	batch.finish(files[1])
+
+	expected := [][2]int64{{50, 350}, {150, 400}, {250, 400}, {400, 400}}
+	if !reflect.DeepEqual(reports, expected) {
+		t.Errorf("Unexpected reports.\nExpected: %v\nActual: %v", expected, reports)
+	}
+}
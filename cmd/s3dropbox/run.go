@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/formscrape"
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/source"
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+const (
+	exitOK = iota
+	exitFailure
+	exitUsage
+	exitPartialFailure
+)
+
+/*
+conditionList collects a repeated command line flag such as
+--condition acl=private --condition bucket=my-s3dropbox.
+*/
+type conditionList []string
+
+func (c *conditionList) String() string {
+	return strings.Join(*c, ", ")
+}
+
+func (c *conditionList) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
+type options struct {
+	policy              string
+	expiration          string
+	conditions          conditionList
+	conditionStartsWith conditionList
+	conditionRange      conditionList
+	awsSecretKeyId      string
+	awsSecretKey        string
+	profile             string
+	signatureVersion    int
+	region              string
+	formIndex           int
+	output              string
+	progress            string
+	maxAttempts         int
+	workers             int
+	keyTemplate         string
+	endpoint            string
+	pathStyle           bool
+	dualStack           bool
+	accelerate          bool
+	contentType         string
+}
+
+func newFlagSet(stderr io.Writer, o *options) *flag.FlagSet {
+	flags := flag.NewFlagSet("s3dropbox", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&o.policy, "policy", "", "policy document used to upload: a file, http(s) URL, web page with an upload form, - for stdin or data: URI")
+	flags.StringVar(&o.expiration, "expiration", "", "expiration of a new policy, e.g. 2023-12-31T23:59:59.000Z")
+	conditionFlags(flags, o)
+	signingFlags(flags, o)
+	endpointFlags(flags, o)
+	flags.IntVar(&o.formIndex, "form-index", 0, "upload form to use when --policy is a web page with several")
+	flags.StringVar(&o.output, "output", "", "write the new policy document to this file instead of stdout")
+	flags.IntVar(&o.maxAttempts, "max-attempts", transport.DefaultRetryPolicy.MaxAttempts, "attempts made to upload a file when S3 fails transiently")
+	flags.StringVar(&o.keyTemplate, "key", "", "key `template` using {prefix}, {filename}, {date}, {uuid} and {sha256} (default {prefix}{filename})")
+	flags.StringVar(&o.contentType, "content-type", "", "send files with this content type instead of detecting it from the name or contents")
+	flags.IntVar(&o.workers, "workers", transport.DefaultWorkers, "files uploaded at once")
+	flags.StringVar(&o.progress, "progress", progressAuto, "upload progress on stderr: auto (a bar on a terminal), bar, json lines or none")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage:\n")
+		fmt.Fprintf(stderr, "  s3dropbox --policy <policy> <file or directory> ...\n")
+		fmt.Fprintf(stderr, "  s3dropbox --expiration <time> [--condition field=value ...] [--output <file>]\n")
+		fmt.Fprintf(stderr, "  s3dropbox policy lint <policy>\n")
+		fmt.Fprintf(stderr, "  s3dropbox form [--format html|json] <policy>\n")
+		fmt.Fprintf(stderr, "  s3dropbox serve --config <file> [--addr :8080]\n\n")
+		fmt.Fprintf(stderr, "Credentials are taken from --aws-secret-key-id and --aws-secret-key, the\n")
+		fmt.Fprintf(stderr, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables or\n")
+		fmt.Fprintf(stderr, "the --profile (default AWS_PROFILE or default) of ~/.aws/credentials.\n\n")
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+/*
+conditionFlags adds the --condition flags used to build a policy.
+*/
+func conditionFlags(flags *flag.FlagSet, o *options) {
+	flags.Var(&o.conditions, "condition", "exact match condition `field=value` (repeatable)")
+	flags.Var(&o.conditionStartsWith, "condition-startswith", "starts-with condition `$field=prefix` (repeatable)")
+	flags.Var(&o.conditionRange, "condition-range", "range condition `field,min,max` (repeatable)")
+}
+
+/*
+signingFlags adds the flags that choose the credentials and signature.
+*/
+func signingFlags(flags *flag.FlagSet, o *options) {
+	flags.StringVar(&o.awsSecretKeyId, "aws-secret-key-id", "", "AWS access key id used to sign the policy")
+	flags.StringVar(&o.awsSecretKey, "aws-secret-key", "", "AWS secret key used to sign the policy")
+	flags.StringVar(&o.profile, "profile", "", "profile in ~/.aws/credentials used to sign the policy")
+	flags.IntVar(&o.signatureVersion, "signature-version", transport.SignatureV2, "sign uploads with AWS signature version 2 or 4")
+	flags.StringVar(&o.region, "region", "us-east-1", "region uploads are sent to and signature version 4 uploads are signed for")
+}
+
+/*
+endpointFlags adds the flags that choose the URL uploads are posted to.
+*/
+func endpointFlags(flags *flag.FlagSet, o *options) {
+	flags.StringVar(&o.endpoint, "endpoint", "", "`URL` of an S3 compatible store such as MinIO, e.g. http://localhost:9000")
+	flags.BoolVar(&o.pathStyle, "path-style", false, "address the bucket by path rather than host name")
+	flags.BoolVar(&o.dualStack, "dualstack", false, "upload to the IPv4 and IPv6 dual-stack endpoint")
+	flags.BoolVar(&o.accelerate, "accelerate", false, "upload through S3 Transfer Acceleration")
+}
+
+/*
+endpointOptions are the transport options selected by endpointFlags.
+*/
+func (o *options) endpointOptions() (opts []transport.Option) {
+	if o.endpoint != "" {
+		opts = append(opts, transport.WithEndpoint(o.endpoint))
+	}
+	if o.pathStyle {
+		opts = append(opts, transport.WithPathStyle())
+	}
+	if o.dualStack {
+		opts = append(opts, transport.WithDualStack())
+	}
+	if o.accelerate {
+		opts = append(opts, transport.WithAccelerate())
+	}
+	return
+}
+
+/*
+formOptions are the transport options that sign and address a form as
+signingFlags and endpointFlags select.
+*/
+func (o *options) formOptions() []transport.Option {
+	return append([]transport.Option{transport.WithSignatureVersion(o.signatureVersion), transport.WithRegion(o.region)}, o.endpointOptions()...)
+}
+
+/*
+run executes the command line and returns the process exit code.
+*/
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "policy" {
+		return runPolicy(args[1:], stdin, stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "form" {
+		return runForm(args[1:], stdin, stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "serve" {
+		return runServe(args[1:], stdin, stdout, stderr)
+	}
+
+	o := &options{}
+	flags := newFlagSet(stderr, o)
+	if ok := flags.Parse(args); ok != nil {
+		if ok == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	switch {
+	case o.policy != "" && o.expiration != "":
+		return usageError(stderr, flags, "--policy and --expiration can not be combined.")
+	case o.policy != "":
+		if flags.NArg() == 0 {
+			return usageError(stderr, flags, "At least one file or directory to upload is required.")
+		}
+		return upload(o, flags.Args(), stdin, stdout, stderr)
+	case o.expiration != "":
+		if flags.NArg() != 0 {
+			return usageError(stderr, flags, "Unexpected arguments when creating a policy.")
+		}
+		return failure(stderr, createPolicy(o, stdout))
+	default:
+		return usageError(stderr, flags, "Either --policy or --expiration is required.")
+	}
+}
+
+func usageError(stderr io.Writer, flags *flag.FlagSet, message string) int {
+	fmt.Fprintf(stderr, "s3dropbox: %s\n", message)
+	flags.Usage()
+	return exitUsage
+}
+
+func failure(stderr io.Writer, ok error) int {
+	if ok == nil {
+		return exitOK
+	}
+	fmt.Fprintf(stderr, "s3dropbox: %s\n", ok)
+	return exitFailure
+}
+
+func (o *options) credentials() credentials.Credentials {
+	return credentials.NewChain(o.awsSecretKeyId, o.awsSecretKey, o.profile)
+}
+
+/*
+retrieveCredentials retrieves the credentials that sign policies, and
+explains how to give them when there are none.
+*/
+func (o *options) retrieveCredentials() (creds credentials.Value, ok error) {
+	creds, ok = o.credentials().Retrieve()
+	if errors.Is(ok, credentials.ErrNotFound) {
+		return creds, errors.New("AWS credentials are required.  Use --aws-secret-key-id and --aws-secret-key, the environment or --profile.")
+	}
+	return creds, ok
+}
+
+/*
+upload sends every file named by paths, walking directories, and returns the
+exit code: exitPartialFailure when only some of the files failed.
+*/
+func upload(o *options, paths []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	ctx := context.Background()
+	resolver := &source.Resolver{Stdin: stdin}
+	raw, ok := resolver.Fetch(ctx, o.policy)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+
+	files, ok := transport.WalkFiles(paths)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	if len(files) == 0 {
+		return failure(stderr, errors.New("No files to upload."))
+	}
+
+	label := files[0].Path
+	if len(files) > 1 {
+		label = fmt.Sprintf("%d files", len(files))
+	}
+	progress, ok := newProgress(o.progress, label, stderr)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+
+	retry := transport.DefaultRetryPolicy
+	retry.MaxAttempts = o.maxAttempts
+	opts := []transport.Option{
+		transport.WithCredentials(o.credentials()),
+		transport.WithSignatureVersion(o.signatureVersion),
+		transport.WithRegion(o.region),
+		transport.WithRetryPolicy(retry),
+	}
+	if o.keyTemplate != "" {
+		opts = append(opts, transport.WithKeyTemplate(o.keyTemplate))
+	}
+	if o.contentType != "" {
+		opts = append(opts, transport.WithContentType(o.contentType))
+	}
+	opts = append(opts, o.endpointOptions()...)
+	if formscrape.IsHTML(raw) {
+		form, ok := scrapeForm(raw, o.policy, o.formIndex)
+		if ok != nil {
+			return failure(stderr, ok)
+		}
+		raw = form.RawPolicy
+		opts = append(opts, transport.WithPresignedForm(form.Action, form.Fields))
+	}
+
+	batch := &transport.Batch{Policy: raw, Workers: o.workers, Options: opts}
+	if ok = batch.Check(files); ok != nil {
+		return failure(stderr, ok)
+	}
+	var combined *batchProgress
+	if progress != nil {
+		combined = newBatchProgress(files, progress)
+		batch.Progress = combined.update
+	}
+	failed := 0
+	batch.Done = func(r transport.BatchResult) {
+		if combined != nil {
+			combined.finish(r.File)
+		}
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(stderr, "s3dropbox: %s: %s\n", r.File.Path, r.Err)
+			return
+		}
+		fmt.Fprintf(stdout, "uploaded %s to s3://%s/%s (etag %s)\n", r.File.Path, r.Result.Bucket, r.Result.Key, r.Result.ETag)
+	}
+	batch.Upload(ctx, files)
+
+	if len(files) > 1 {
+		fmt.Fprintf(stdout, "%d of %d files uploaded, %d failed\n", len(files)-failed, len(files), failed)
+	}
+	switch failed {
+	case 0:
+		return exitOK
+	case len(files):
+		return exitFailure
+	default:
+		return exitPartialFailure
+	}
+}
+
+/*
+scrapeForm picks the upload form at index from an HTML page.
+*/
+func scrapeForm(page []byte, location string, index int) (form *formscrape.Form, ok error) {
+	base, _ := url.Parse(location)
+	forms, ok := formscrape.Scrape(bytes.NewReader(page), base)
+	if ok != nil {
+		return nil, fmt.Errorf("%s: %s", location, ok)
+	}
+	if index < 0 || index >= len(forms) {
+		return nil, fmt.Errorf("%s: --form-index %d is out of range, the page has %d upload forms.", location, index, len(forms))
+	}
+	return forms[index], nil
+}
+
+/*
+buildPolicy translates the --expiration and --condition* flags into a policy.
+*/
+func buildPolicy(o *options) (p *policy.Policy, ok error) {
+	expiration, ok := time.Parse(time.RFC3339, o.expiration)
+	if ok != nil {
+		return nil, fmt.Errorf("Invalid expiration %q: %s", o.expiration, ok)
+	}
+	if p, ok = policy.NewPolicy(expiration); ok != nil {
+		return nil, ok
+	}
+	if ok = addConditions(p, o); ok != nil {
+		return nil, ok
+	}
+	return p, nil
+}
+
+/*
+addConditions adds the conditions of the --condition* flags to p.
+*/
+func addConditions(p *policy.Policy, o *options) (ok error) {
+	for _, condition := range o.conditions {
+		field, value, found := strings.Cut(condition, "=")
+		if !found || field == "" {
+			return fmt.Errorf("Invalid condition %q.  Expected field=value.", condition)
+		}
+		p.AddConditionEq(field, value)
+	}
+
+	for _, condition := range o.conditionStartsWith {
+		field, value, found := strings.Cut(condition, "=")
+		if !found || field == "" {
+			return fmt.Errorf("Invalid starts-with condition %q.  Expected $field=prefix.", condition)
+		}
+		if ok = p.AddConditionStartsWith(field, value); ok != nil {
+			return ok
+		}
+	}
+
+	for _, condition := range o.conditionRange {
+		field, min, max, ok := parseRange(condition)
+		if ok != nil {
+			return ok
+		}
+		p.AddConditionRange(field, min, max)
+	}
+	return nil
+}
+
+/*
+parseRange parses field,min,max.  S3 only supports content-length-range so
+both $content-length and content-length are accepted as its name.
+*/
+func parseRange(condition string) (field string, min, max float64, ok error) {
+	parts := strings.Split(condition, ",")
+	if len(parts) != 3 {
+		return "", 0, 0, fmt.Errorf("Invalid range condition %q.  Expected field,min,max.", condition)
+	}
+	field = strings.TrimPrefix(parts[0], "$")
+	if !strings.HasSuffix(field, "-range") {
+		field += "-range"
+	}
+	if !strings.EqualFold(field, "content-length-range") {
+		return "", 0, 0, fmt.Errorf("Invalid range condition %q.  S3 only supports content-length.", condition)
+	}
+	if min, ok = strconv.ParseFloat(parts[1], 64); ok != nil {
+		return "", 0, 0, fmt.Errorf("Invalid range minimum %q.", parts[1])
+	}
+	if max, ok = strconv.ParseFloat(parts[2], 64); ok != nil {
+		return "", 0, 0, fmt.Errorf("Invalid range maximum %q.", parts[2])
+	}
+	if min > max {
+		return "", 0, 0, fmt.Errorf("Invalid range condition %q.  Minimum is greater than maximum.", condition)
+	}
+	return field, min, max, nil
+}
+
+/*
+createPolicy writes the policy document to --output (or stdout) and reports
+the base64 encoded policy and its signature.
+*/
+func createPolicy(o *options, stdout io.Writer) error {
+	p, ok := buildPolicy(o)
+	if ok != nil {
+		return ok
+	}
+	signed, ok := signPolicy(o, p)
+	if ok != nil {
+		return ok
+	}
+	return writeSignedPolicy(o.output, signed, stdout)
+}
+
+/*
+signedPolicy is a policy document with its base64 encoding, signature and
+the form fields that carry them.
+*/
+type signedPolicy struct {
+	Document  json.RawMessage   `json:"document"`
+	Policy    string            `json:"policy"`
+	Signature string            `json:"signature"`
+	Fields    map[string]string `json:"fields"`
+}
+
+/*
+signPolicy signs p with the credentials and signature version of o.
+*/
+func signPolicy(o *options, p *policy.Policy) (signed *signedPolicy, ok error) {
+	creds, ok := o.retrieveCredentials()
+	if ok != nil {
+		return nil, ok
+	}
+	fields, ok := transport.SignPolicy(p, creds, transport.WithSignatureVersion(o.signatureVersion), transport.WithRegion(o.region))
+	if ok != nil {
+		return nil, ok
+	}
+	doc, ok := p.Canonical()
+	if ok != nil {
+		return nil, ok
+	}
+
+	signed = &signedPolicy{Document: doc, Fields: map[string]string{}}
+	for _, condition := range p.Conditions {
+		switch name := policy.FieldName(condition.Name()); name {
+		case "x-amz-algorithm", "x-amz-credential", "x-amz-date", "x-amz-security-token":
+			signed.Fields[name] = condition.ValueString()
+		}
+	}
+	for _, field := range fields {
+		signed.Fields[field.Name] = field.Value
+		switch field.Name {
+		case "policy":
+			signed.Policy = field.Value
+		case "signature", "x-amz-signature":
+			signed.Signature = field.Value
+		}
+	}
+	return signed, nil
+}
+
+/*
+writeSignedPolicy writes the policy document to output, or stdout when it is
+empty, followed by the base64 encoded policy and its signature.
+*/
+func writeSignedPolicy(output string, signed *signedPolicy, stdout io.Writer) (ok error) {
+	if output == "" {
+		_, ok = fmt.Fprintf(stdout, "%s\n", signed.Document)
+	} else {
+		ok = os.WriteFile(output, append(signed.Document, '\n'), 0644)
+	}
+	if ok != nil {
+		return ok
+	}
+	_, ok = fmt.Fprintf(stdout, "policy: %s\nsignature: %s\n", signed.Policy, signed.Signature)
+	return ok
+}
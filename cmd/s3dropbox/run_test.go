@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/s3test"
+)
+
+func runCommand(args ...string) (code int, stdout, stderr string) {
+	var out, err bytes.Buffer
+	code = run(args, strings.NewReader(""), &out, &err)
+	return code, out.String(), err.String()
+}
+
+func TestDegenerateNoArguments(t *testing.T) {
+	if code, _, _ := runCommand(); code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestDegeneratePolicyAndExpiration(t *testing.T) {
+	if code, _, _ := runCommand("--policy", "p", "--expiration", "2023-12-31T23:59:59.000Z", "f"); code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestDegenerateUploadMissingFile(t *testing.T) {
+	if code, _, _ := runCommand("--policy", "p"); code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestDegenerateUploadMissingPolicy(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.policy")
+	code, _, stderr := runCommand("--policy", missing, "file1.ext")
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	if !strings.Contains(stderr, "missing.policy") {
+		t.Errorf("Expected the missing policy to be reported, got: %s", stderr)
+	}
+}
+
+func TestDegenerateUploadMissingUploadFile(t *testing.T) {
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "upload.policy")
+	os.WriteFile(policyFile, []byte(`{"expiration": "2023-12-31T23:59:59.000Z", "conditions": [{"bucket": "b"}, ["starts-with", "$key", ""]]}`), 0644)
+	os.WriteFile(filepath.Join(dir, "present"), []byte("file contents"), 0644)
+	code, _, stderr := runCommand("--policy", policyFile, filepath.Join(dir, "present"), filepath.Join(dir, "missing.ext"))
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	if !strings.Contains(stderr, "missing.ext") {
+		t.Errorf("Expected the missing file to be reported, got: %s", stderr)
+	}
+}
+
+func clearCredentials(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_SHARED_CREDENTIALS_FILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("HOME", t.TempDir())
+}
+
+func TestDegenerateCreatePolicyWithoutCredentials(t *testing.T) {
+	clearCredentials(t)
+	code, _, _ := runCommand("--expiration", "2023-12-31T23:59:59.000Z", "--condition", "bucket=b", "--condition-startswith", "$key=k")
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+}
+
+func TestDegenerateCreatePolicyInvalidRange(t *testing.T) {
+	code, _, _ := runCommand("--expiration", "2023-12-31T23:59:59.000Z", "--condition-range", "$content-length,2048,1024",
+		"--aws-secret-key-id=id", "--aws-secret-key=secret")
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+}
+
+func TestDegenerateCreatePolicyUnknownRange(t *testing.T) {
+	code, _, stderr := runCommand("--expiration", "2023-12-31T23:59:59.000Z", "--condition-range", "$x-amz-meta-size,1,10",
+		"--aws-secret-key-id=id", "--aws-secret-key=secret")
+	if code != exitFailure || !strings.Contains(stderr, "only supports content-length") {
+		t.Errorf("Expected exit code %d for an unknown range, got %d: %s", exitFailure, code, stderr)
+	}
+}
+
+func TestCreatePolicyREADMEExample(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "upload.policy")
+	code, stdout, stderr := runCommand("--expiration", "2023-12-31T23:59:59.000Z", "--condition", "acl=private",
+		"--condition-startswith", "$key=user/upload", "--condition", "bucket=my-s3dropbox",
+		"--condition-range", "$content-length,1024,2048", "--aws-secret-key-id=id", "--aws-secret-key=secret",
+		"--output", output)
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	if !strings.Contains(stdout, "policy: ") || !strings.Contains(stdout, "signature: ") {
+		t.Errorf("Expected the encoded policy and signature on stdout, got: %s", stdout)
+	}
+
+	doc, ok := os.ReadFile(output)
+	if ok != nil {
+		t.Fatalf("Policy document not written: %s", ok)
+	}
+	p, ok := policy.ParsePolicy(doc)
+	if ok != nil {
+		t.Fatalf("Unable to parse the generated policy: %s", ok)
+	}
+	if !p.ConditionMatches("acl", "private") || !p.ConditionMatches("bucket", "my-s3dropbox") {
+		t.Errorf("Exact match conditions missing: %s", doc)
+	}
+	if c, found := p.Condition("$key"); !found || c.ValueString() != "user/upload" {
+		t.Errorf("starts-with condition missing: %s", doc)
+	}
+	if c, found := p.Condition("content-length-range"); !found || c.ValueString() != "1024 2048" {
+		t.Errorf("range condition missing: %s", doc)
+	}
+}
+
+func TestCreatePolicyCredentialsFromEnvironment(t *testing.T) {
+	clearCredentials(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	code, _, stderr := runCommand("--expiration", "2023-12-31T23:59:59.000Z", "--condition", "bucket=b", "--condition-startswith", "$key=k")
+	if code != exitOK {
+		t.Errorf("Expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, os.ErrClosed
+}
+
+func TestDegenerateCreatePolicyWriteFailure(t *testing.T) {
+	var stderr bytes.Buffer
+	code := run([]string{"--expiration", "2023-12-31T23:59:59.000Z", "--condition", "bucket=b", "--condition-startswith", "$key=k",
+		"--aws-secret-key-id=id", "--aws-secret-key=secret"}, strings.NewReader(""), failingWriter{}, &stderr)
+	if code != exitFailure || !strings.Contains(stderr.String(), os.ErrClosed.Error()) {
+		t.Errorf("A failed write should be reported, got %d: %s", code, stderr.String())
+	}
+}
+
+func TestDegenerateUploadRemotePolicyNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	code, _, stderr := runCommand("--policy", server.URL+"/remote.policy", "file1.ext")
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	if !strings.Contains(stderr, "404") {
+		t.Errorf("Expected the failed fetch to be reported, got: %s", stderr)
+	}
+}
+
+func TestDegenerateUploadPageWithoutForm(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<!DOCTYPE html><html><body><form action="/search"><input name="q"></form></body></html>`))
+	}))
+	defer server.Close()
+	file := filepath.Join(t.TempDir(), "file1.ext")
+	os.WriteFile(file, []byte("file contents"), 0644)
+
+	code, _, stderr := runCommand("--policy", server.URL+"/form", file)
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	if !strings.Contains(stderr, "No S3 upload form") {
+		t.Errorf("Expected the missing form to be reported, got: %s", stderr)
+	}
+}
+
+func TestUploadBatchPartialFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/b/" {
+			t.Errorf("Expected the form to be posted to the bucket, got %s", r.URL.Path)
+		}
+		if strings.Contains(r.FormValue("key"), "bad") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "upload.policy")
+	os.WriteFile(policyFile, []byte(`{"expiration": "2023-12-31T23:59:59.000Z", "conditions": [{"bucket": "b"}, ["starts-with", "$key", "up/"]]}`), 0644)
+	os.MkdirAll(filepath.Join(dir, "files", "sub"), 0755)
+	for _, name := range []string{"good.txt", "bad.txt", "sub/also-good.txt"} {
+		os.WriteFile(filepath.Join(dir, "files", name), []byte(name), 0644)
+	}
+
+	code, stdout, stderr := runCommand("--policy", policyFile, "--endpoint", server.URL, "--aws-secret-key-id=id", "--aws-secret-key=secret",
+		"--progress", "none", filepath.Join(dir, "files"))
+	if code != exitPartialFailure {
+		t.Errorf("Expected exit code %d, got %d: %s", exitPartialFailure, code, stderr)
+	}
+	if !strings.Contains(stdout, "s3://b/up/sub/also-good.txt") || !strings.Contains(stdout, "2 of 3 files uploaded, 1 failed") {
+		t.Errorf("Expected the uploads and a summary, got: %s", stdout)
+	}
+	if !strings.Contains(stderr, "bad.txt") {
+		t.Errorf("Expected the failed file to be reported, got: %s", stderr)
+	}
+}
+
+func TestDegenerateUploadBatchExactKey(t *testing.T) {
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "upload.policy")
+	os.WriteFile(policyFile, []byte(`{"expiration": "2023-12-31T23:59:59.000Z", "conditions": [{"bucket": "b"}, ["eq", "$key", "up/fixed.txt"]]}`), 0644)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+
+	code, _, stderr := runCommand("--policy", policyFile, "--aws-secret-key-id=id", "--aws-secret-key=secret",
+		"--progress", "none", filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"))
+	if code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d: %s", exitFailure, code, stderr)
+	}
+	if !strings.Contains(stderr, "only upload one file") {
+		t.Errorf("Expected the fixed key to be reported, got: %s", stderr)
+	}
+}
+
+func TestUploadScrapedForm(t *testing.T) {
+	server := s3test.NewServer(s3test.WithCredentials(credentials.Value{AccessKeyId: "foobar", SecretAccessKey: "barfoo"}))
+	defer server.Close()
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = server.Client().Transport
+	t.Cleanup(func() { http.DefaultClient.Transport = transport })
+
+	expiration := time.Now().Add(time.Hour).UTC().Format(policy.ExpirationFormat)
+	p, _ := policy.ParsePolicy([]byte(`{"expiration": "` + expiration + `", "conditions": [{"bucket": "johnsmith"},
+  ["starts-with", "$key", "user/eric/"], {"acl": "public-read"}, ["starts-with", "$Content-Type", "text/"]]}`))
+	signer, _ := policy.NewS3DropboxSigner("foobar", "barfoo")
+	signer.AddPolicy(p)
+	encoded, sig, _ := signer.Sign()
+
+	// Laid out like the browser upload example in the S3 documentation,
+	// with a submit button and an input after the file, both ignored by S3.
+	dir := t.TempDir()
+	page := filepath.Join(dir, "upload.html")
+	os.WriteFile(page, []byte(`<!DOCTYPE html>
+<html><body>
+  <form action="https://johnsmith.s3.amazonaws.com/" method="post" enctype="multipart/form-data">
+    Key to upload: <input type="input" name="key" value="user/eric/${filename}" /><br />
+    <input type="hidden" name="acl" value="public-read">
+    Content-Type: <input type="input" name="Content-Type" value="text/plain" /><br />
+    <input type="hidden" name="AWSAccessKeyId" value="foobar" />
+    <input type="hidden" name="Policy" value="`+string(encoded)+`" />
+    <input type="hidden" name="Signature" value="`+string(sig)+`" />
+    File: <input type="file" name="file" /> <br />
+    <input type="hidden" name="x-amz-meta-after" value="ignored" />
+    <input type="submit" name="submit" value="Upload to Amazon S3" />
+  </form>
+</body></html>
+`), 0644)
+	file := filepath.Join(dir, "file1.ext")
+	os.WriteFile(file, []byte("file contents"), 0644)
+
+	code, stdout, stderr := runCommand("--policy", page, "--progress", "none", file)
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr)
+	}
+	if _, found := server.Object("johnsmith", "user/eric/file1.ext"); !found {
+		t.Errorf("Object not stored: %v\n%s", server.Objects(), stdout)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/signserver"
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+/*
+runServe signs policies for browser uploads over HTTP until interrupted.
+*/
+func runServe(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	o := &options{}
+	flags := flag.NewFlagSet("s3dropbox serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configFile := flags.String("config", "", "JSON `file` with the bucket and the token, key_prefix, max_size, content_types, expires_in and rate_limit of each caller")
+	addr := flags.String("addr", ":8080", "`address` to listen on")
+	signingFlags(flags, o)
+	endpointFlags(flags, o)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage:\n")
+		fmt.Fprintf(stderr, "  s3dropbox serve --config <file> [--addr :8080]\n\n")
+		fmt.Fprintf(stderr, "Callers POST {\"filename\": ..., \"size\": ..., \"content_type\": ...} to /sign\n")
+		fmt.Fprintf(stderr, "with an Authorization: Bearer <token> header.  /healthz answers ok.\n\n")
+		flags.PrintDefaults()
+	}
+	if ok := flags.Parse(args); ok != nil {
+		if ok == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	switch {
+	case flags.NArg() != 0:
+		return usageError(stderr, flags, "Unexpected arguments when serving.")
+	case *configFile == "":
+		return usageError(stderr, flags, "--config is required.")
+	}
+
+	doc, ok := os.ReadFile(*configFile)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+	config, ok := signserver.ParseConfig(doc)
+	if ok != nil {
+		return failure(stderr, fmt.Errorf("%s: %s", *configFile, ok))
+	}
+	handler, ok := newSignServer(o, config)
+	if ok != nil {
+		return failure(stderr, ok)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.ListenAndServe() }()
+	fmt.Fprintf(stderr, "s3dropbox: Signing uploads to %s on %s\n", config.Bucket, *addr)
+
+	select {
+	case ok = <-served:
+		return failure(stderr, ok)
+	case <-ctx.Done():
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return failure(stderr, server.Shutdown(shutdown))
+	}
+}
+
+/*
+newSignServer signs a policy for config's bucket once, so a server that can
+not sign with the credentials and signature version of o fails to start, and
+returns the handler that signs with them.
+*/
+func newSignServer(o *options, config *signserver.Config) (*signserver.Server, error) {
+	creds, ok := o.retrieveCredentials()
+	if ok != nil {
+		return nil, ok
+	}
+	probe, ok := policy.NewPolicy(time.Now().Add(time.Minute))
+	if ok != nil {
+		return nil, ok
+	}
+	probe.AddConditionEq("bucket", config.Bucket)
+	if _, ok = transport.SignPolicy(probe, creds, o.formOptions()...); ok != nil {
+		return nil, fmt.Errorf("Unable to sign uploads: %s", ok)
+	}
+
+	opts := append(config.Options(), signserver.WithTransportOptions(o.formOptions()...))
+	return signserver.New(config.Bucket, o.credentials(), opts...), nil
+}
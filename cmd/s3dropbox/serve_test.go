@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/noahcampbell/s3dropbox/formgen"
+	"github.com/noahcampbell/s3dropbox/signserver"
+)
+
+const serve_config = `{"bucket": "my-s3dropbox",
+  "callers": [{"name": "eric", "token": "eric-token", "key_prefix": "uploads/eric/", "max_size": "10MB"}]}`
+
+func TestServeSignsWithCredentials(t *testing.T) {
+	config, _ := signserver.ParseConfig([]byte(serve_config))
+	o := &options{awsSecretKeyId: "id", awsSecretKey: "secret", signatureVersion: 4, region: "eu-west-1", pathStyle: true}
+	handler, ok := newSignServer(o, config)
+	if ok != nil {
+		t.Fatalf("Unable to create the server: %s", ok)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/sign", strings.NewReader(`{"filename": "notes.txt", "size": 10}`))
+	request.Header.Set("Authorization", "Bearer eric-token")
+	response, ok := server.Client().Do(request)
+	if ok != nil {
+		t.Fatalf("Request failed: %s", ok)
+	}
+	defer response.Body.Close()
+	var form formgen.Form
+	if ok = json.NewDecoder(response.Body).Decode(&form); ok != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected response %d: %v", response.StatusCode, ok)
+	}
+	if form.Action != "https://s3.eu-west-1.amazonaws.com/my-s3dropbox/" {
+		t.Errorf("Unexpected action: %s", form.Action)
+	}
+	values := map[string]string{}
+	for _, field := range form.Fields {
+		values[field.Name] = field.Value
+	}
+	if values["key"] != "uploads/eric/notes.txt" || !strings.HasPrefix(values["x-amz-credential"], "id/") || values["x-amz-signature"] == "" {
+		t.Errorf("Unexpected fields: %v", values)
+	}
+}
+
+func TestDegenerateServe(t *testing.T) {
+	clearCredentials(t)
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(serve_config), 0644)
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"bucket": "my-s3dropbox"}`), 0644)
+
+	for _, test := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"serve"}, exitUsage},
+		{[]string{"serve", "--config", valid, "extra"}, exitUsage},
+		{[]string{"serve", "--config", filepath.Join(dir, "missing.json")}, exitFailure},
+		{[]string{"serve", "--config", invalid, "--aws-secret-key-id=id", "--aws-secret-key=secret"}, exitFailure},
+		{[]string{"serve", "--config", valid}, exitFailure},
+		{[]string{"serve", "--config", valid, "--signature-version", "3", "--aws-secret-key-id=id", "--aws-secret-key=secret"}, exitFailure},
+	} {
+		if code, _, _ := runCommand(test.args...); code != test.code {
+			t.Errorf("%v: Expected exit code %d, got %d", test.args, test.code, code)
+		}
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	config, _ := signserver.ParseConfig([]byte(serve_config))
+	if _, ok := newSignServer(&options{signatureVersion: 2}, config); ok == nil {
+		t.Errorf("Temporary credentials should require signature version 4")
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Package credentials locates the AWS credentials policies are signed with.
+
+Credentials are resolved by a chain of providers: values given explicitly,
+the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+environment variables and finally a profile in the shared credentials file,
+~/.aws/credentials.
+*/
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+ErrNotFound is returned by a provider that has no credentials to offer.
+*/
+var ErrNotFound = errors.New("No AWS credentials found.")
+
+/*
+Value is a set of AWS credentials.  SessionToken is only set for temporary
+credentials.
+*/
+type Value struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+/*
+Credentials provides a Value on demand.
+*/
+type Credentials interface {
+	Retrieve() (value Value, ok error)
+}
+
+/*
+Static provides credentials that are known up front, e.g. from command line
+flags.
+*/
+type Static Value
+
+/*
+NewStatic creates credentials from explicit values.
+*/
+func NewStatic(accessKeyId, secretAccessKey, sessionToken string) *Static {
+	return &Static{accessKeyId, secretAccessKey, sessionToken}
+}
+
+func (s *Static) Retrieve() (value Value, ok error) {
+	if s.AccessKeyId == "" || s.SecretAccessKey == "" {
+		return value, ErrNotFound
+	}
+	return Value(*s), nil
+}
+
+/*
+Env provides credentials from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY
+and AWS_SESSION_TOKEN environment variables.
+*/
+type Env struct{}
+
+func (Env) Retrieve() (value Value, ok error) {
+	value = Value{
+		AccessKeyId:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if value.AccessKeyId == "" && value.SecretAccessKey == "" {
+		return Value{}, ErrNotFound
+	}
+	if value.AccessKeyId == "" {
+		return Value{}, errors.New("AWS_SECRET_ACCESS_KEY is set but AWS_ACCESS_KEY_ID is not.")
+	}
+	if value.SecretAccessKey == "" {
+		return Value{}, errors.New("AWS_ACCESS_KEY_ID is set but AWS_SECRET_ACCESS_KEY is not.")
+	}
+	return value, nil
+}
+
+/*
+Chain returns the credentials of the first provider that has them.  A
+provider returning an error other than ErrNotFound stops the search so that
+broken configuration is reported rather than silently skipped.
+*/
+type Chain []Credentials
+
+func (c Chain) Retrieve() (value Value, ok error) {
+	for _, provider := range c {
+		value, ok = provider.Retrieve()
+		if ok == nil {
+			return value, nil
+		}
+		if !errors.Is(ok, ErrNotFound) {
+			return Value{}, ok
+		}
+	}
+	return Value{}, ErrNotFound
+}
+
+/*
+NewChain resolves credentials from the explicit values, when given, then the
+environment and finally profile of the shared credentials file.  An empty
+profile uses AWS_PROFILE or "default".
+*/
+func NewChain(accessKeyId, secretAccessKey, profile string) Credentials {
+	chain := Chain{}
+	if accessKeyId != "" || secretAccessKey != "" {
+		chain = append(chain, explicit{NewStatic(accessKeyId, secretAccessKey, "")})
+	}
+	if profile == "" {
+		chain = append(chain, Env{})
+	}
+	return append(chain, &SharedFile{Profile: profile})
+}
+
+/*
+explicit reports incomplete values as an error instead of falling through
+to the next provider.
+*/
+type explicit struct {
+	*Static
+}
+
+func (e explicit) Retrieve() (value Value, ok error) {
+	if e.AccessKeyId == "" || e.SecretAccessKey == "" {
+		return value, errors.New("Both an access key id and a secret key are required.")
+	}
+	return e.Static.Retrieve()
+}
+
+func (v Value) String() string {
+	// Never print the secret.
+	return fmt.Sprintf("%s:%s", v.AccessKeyId, strings.Repeat("*", 8))
+}
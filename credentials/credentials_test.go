@@ -0,0 +1,132 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const shared_credentials_example = `# shared credentials
+[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = default/secret
+
+[ci]
+aws_access_key_id=AKIDCI
+aws_secret_access_key=ci/secret
+aws_session_token = citoken
+
+[broken]
+aws_access_key_id = AKIDBROKEN
+`
+
+func clearEnv(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_SHARED_CREDENTIALS_FILE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("HOME", t.TempDir())
+}
+
+func writeSharedFile(t *testing.T) string {
+	filename := filepath.Join(t.TempDir(), "credentials")
+	if ok := os.WriteFile(filename, []byte(shared_credentials_example), 0600); ok != nil {
+		t.Fatalf("Unable to write credentials file: %s", ok)
+	}
+	return filename
+}
+
+func checkValue(t *testing.T, provider Credentials, expected Value) {
+	value, ok := provider.Retrieve()
+	if ok != nil {
+		t.Fatalf("Unable to retrieve credentials: %s", ok)
+	}
+	if value != expected {
+		t.Errorf("Unexpected credentials.  Expected: %#v Actual: %#v", expected, value)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	checkValue(t, NewStatic("id", "secret", ""), Value{"id", "secret", ""})
+}
+
+func TestDegenerateStaticEmpty(t *testing.T) {
+	if _, ok := NewStatic("", "", "").Retrieve(); !errors.Is(ok, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", ok)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "envid")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	t.Setenv("AWS_SESSION_TOKEN", "envtoken")
+	checkValue(t, Env{}, Value{"envid", "envsecret", "envtoken"})
+}
+
+func TestDegenerateEnvPartial(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "envid")
+	if _, ok := (Env{}).Retrieve(); ok == nil || errors.Is(ok, ErrNotFound) {
+		t.Errorf("A partially configured environment should be an error, got: %v", ok)
+	}
+}
+
+func TestSharedFileProfiles(t *testing.T) {
+	clearEnv(t)
+	filename := writeSharedFile(t)
+	checkValue(t, &SharedFile{Filename: filename}, Value{"AKIDDEFAULT", "default/secret", ""})
+	checkValue(t, &SharedFile{Filename: filename, Profile: "ci"}, Value{"AKIDCI", "ci/secret", "citoken"})
+
+	t.Setenv("AWS_PROFILE", "ci")
+	checkValue(t, &SharedFile{Filename: filename}, Value{"AKIDCI", "ci/secret", "citoken"})
+}
+
+func TestSharedFileFromEnvironment(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeSharedFile(t))
+	checkValue(t, &SharedFile{}, Value{"AKIDDEFAULT", "default/secret", ""})
+}
+
+func TestDegenerateSharedFileMissingProfile(t *testing.T) {
+	clearEnv(t)
+	filename := writeSharedFile(t)
+	for _, profile := range []string{"missing", "broken"} {
+		if _, ok := (&SharedFile{Filename: filename, Profile: profile}).Retrieve(); ok == nil || errors.Is(ok, ErrNotFound) {
+			t.Errorf("Profile %s should be an error, got: %v", profile, ok)
+		}
+	}
+}
+
+func TestDegenerateSharedFileMissing(t *testing.T) {
+	clearEnv(t)
+	if _, ok := (&SharedFile{}).Retrieve(); !errors.Is(ok, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", ok)
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeSharedFile(t))
+	checkValue(t, NewChain("", "", ""), Value{"AKIDDEFAULT", "default/secret", ""})
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "envid")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	checkValue(t, NewChain("", "", ""), Value{"envid", "envsecret", ""})
+	checkValue(t, NewChain("", "", "ci"), Value{"AKIDCI", "ci/secret", "citoken"})
+	checkValue(t, NewChain("id", "secret", ""), Value{"id", "secret", ""})
+}
+
+func TestDegenerateChainIncompleteExplicit(t *testing.T) {
+	clearEnv(t)
+	if _, ok := NewChain("id", "", "").Retrieve(); ok == nil || errors.Is(ok, ErrNotFound) {
+		t.Errorf("Incomplete explicit credentials should be an error, got: %v", ok)
+	}
+}
+
+func TestDegenerateChainEmpty(t *testing.T) {
+	clearEnv(t)
+	if _, ok := NewChain("", "", "").Retrieve(); !errors.Is(ok, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", ok)
+	}
+}
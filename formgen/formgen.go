@@ -0,0 +1,204 @@
+/*
+Package formgen generates browser upload forms for S3 POST policies.
+
+New signs a policy and lays out the form S3 expects: the fields of the
+policy's conditions in the order S3 requires, the signature fields and the
+file input last.  The form renders as a complete HTML page with
+DefaultTemplate, or any html/template, or marshals to JSON for pages that
+build the form themselves.
+*/
+package formgen
+
+import (
+	"errors"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+/*
+Field is an input of the form.  Type is hidden for fields the policy fixes
+and text for those it only constrains by prefix, which the uploader may
+fill in.
+*/
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+/*
+Form is a signed upload form.  File is the name of the file input, which
+S3 requires to be the last field.
+*/
+type Form struct {
+	Title      string    `json:"title,omitempty"`
+	Action     string    `json:"action"`
+	Method     string    `json:"method"`
+	Enctype    string    `json:"enctype"`
+	Fields     []Field   `json:"fields"`
+	File       string    `json:"file"`
+	Expiration time.Time `json:"expiration"`
+}
+
+type options struct {
+	key              string
+	title            string
+	transportOptions []transport.Option
+}
+
+/*
+Option customizes a generated form.
+*/
+type Option func(o *options)
+
+/*
+WithKey sets the key field.  By default it is the $key condition's exact
+value, or its prefix followed by ${filename} so S3 names the object after
+the file chosen in the browser.
+*/
+func WithKey(key string) Option {
+	return func(o *options) {
+		o.key = key
+	}
+}
+
+/*
+WithTitle sets the title of the page rendered by DefaultTemplate.
+*/
+func WithTitle(title string) Option {
+	return func(o *options) {
+		o.title = title
+	}
+}
+
+/*
+WithTransportOptions signs the form as an upload with the same options
+would: transport.WithSignatureVersion, WithRegion and WithClock choose the
+signature, and WithEndpoint, WithPathStyle, WithDualStack or WithAccelerate
+the action URL.
+*/
+func WithTransportOptions(opts ...transport.Option) Option {
+	return func(o *options) {
+		o.transportOptions = append(o.transportOptions, opts...)
+	}
+}
+
+/*
+New signs p with creds by transport.SignPolicy and builds its upload form.
+Signature Version 4 adds its signing conditions to p as it signs.
+*/
+func New(p *policy.Policy, creds credentials.Value, opts ...Option) (form *Form, ok error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	bucket, found := p.Condition("bucket")
+	if !found {
+		return nil, errors.New("Missing bucket.  The policy requires a bucket condition.")
+	}
+	action, ok := transport.BucketURL(bucket.ValueString(), o.transportOptions...)
+	if ok != nil {
+		return nil, ok
+	}
+	signature, ok := transport.SignPolicy(p, creds, o.transportOptions...)
+	if ok != nil {
+		return nil, ok
+	}
+
+	key := o.key
+	if key == "" {
+		if key, ok = defaultKey(p); ok != nil {
+			return nil, ok
+		}
+	}
+
+	form = &Form{
+		Title:      o.title,
+		Action:     action.String(),
+		Method:     "post",
+		Enctype:    "multipart/form-data",
+		File:       "file",
+		Expiration: p.Expiration,
+	}
+	for _, field := range transport.FieldsFromPolicy(p, key) {
+		form.Fields = append(form.Fields, Field{field.Name, field.Value, inputType(p, field.Name)})
+	}
+	for _, field := range signature {
+		form.Fields = append(form.Fields, Field{field.Name, field.Value, "hidden"})
+	}
+	return form, nil
+}
+
+func defaultKey(p *policy.Policy) (string, error) {
+	for _, condition := range p.Conditions {
+		if policy.FieldName(condition.Name()) != "key" {
+			continue
+		}
+		if _, isStartsWith := condition.(policy.ConditionStartsWith); isStartsWith {
+			return condition.ValueString() + "${filename}", nil
+		}
+		return condition.ValueString(), nil
+	}
+	return "", errors.New("Missing key.  The policy requires a $key condition.")
+}
+
+/*
+inputType is text for a field the policy constrains only by prefix, other
+than the key, and hidden otherwise.
+*/
+func inputType(p *policy.Policy, name string) string {
+	if strings.EqualFold(name, "key") {
+		return "hidden"
+	}
+	for _, condition := range p.Conditions {
+		if _, isStartsWith := condition.(policy.ConditionStartsWith); isStartsWith && policy.FieldName(condition.Name()) == strings.ToLower(name) {
+			return "text"
+		}
+	}
+	return "hidden"
+}
+
+/*
+DefaultTemplate renders a Form as a complete HTML page.
+*/
+var DefaultTemplate = template.Must(template.New("form").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>{{if .Title}}{{.Title}}{{else}}Upload to Amazon S3{{end}}</title>
+  </head>
+  <body>
+    {{- if .Title}}
+    <h1>{{.Title}}</h1>
+    {{- end}}
+    <form action="{{.Action}}" method="{{.Method}}" enctype="{{.Enctype}}">
+      {{- range .Fields}}
+      {{- if eq .Type "hidden"}}
+      <input type="hidden" name="{{.Name}}" value="{{.Value}}">
+      {{- else}}
+      <label>{{.Name}} <input type="{{.Type}}" name="{{.Name}}" value="{{.Value}}"></label><br>
+      {{- end}}
+      {{- end}}
+      <input type="file" name="{{.File}}"><br>
+      <input type="submit" value="Upload">
+    </form>
+  </body>
+</html>
+`))
+
+/*
+Render writes the form with tmpl, or DefaultTemplate when tmpl is nil.
+*/
+func (f *Form) Render(w io.Writer, tmpl *template.Template) error {
+	if tmpl == nil {
+		tmpl = DefaultTemplate
+	}
+	return tmpl.Execute(w, f)
+}
@@ -0,0 +1,197 @@
+package formgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/formscrape"
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/s3test"
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+const form_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    {"acl": "private"},
+    ["starts-with", "$Content-Type", "text/"],
+    {"success_action_status": "201"},
+    {"x-amz-meta-uuid": "14365123651274"},
+    ["content-length-range", 1, 1024]
+  ]
+}`
+
+var (
+	form_date        = time.Date(2007, 11, 30, 0, 0, 0, 0, time.UTC)
+	test_credentials = credentials.Value{AccessKeyId: "foobar", SecretAccessKey: "barfoo"}
+)
+
+func parseFormPolicy(t *testing.T) *policy.Policy {
+	p, ok := policy.ParsePolicy([]byte(form_policy))
+	if ok != nil {
+		t.Fatalf("Unable to parse the policy: %s", ok)
+	}
+	return p
+}
+
+func fieldNames(fields []Field) (names []string) {
+	for _, field := range fields {
+		names = append(names, field.Name)
+	}
+	return
+}
+
+func TestNewSignatureV2(t *testing.T) {
+	form, ok := New(parseFormPolicy(t), test_credentials, WithTitle("Holiday photos"))
+	if ok != nil {
+		t.Fatalf("Unable to generate the form: %s", ok)
+	}
+	if form.Action != "https://johnsmith.s3.amazonaws.com/" || form.Method != "post" || form.Enctype != "multipart/form-data" || form.File != "file" {
+		t.Errorf("Unexpected form: %+v", form)
+	}
+
+	expectedNames := []string{"key", "acl", "Content-Type", "success_action_status", "x-amz-meta-uuid", "AWSAccessKeyId", "policy", "signature"}
+	if names := fieldNames(form.Fields); !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("Form fields out of order.  Expected:\n%v\nActual:\n%v", expectedNames, names)
+	}
+	if form.Fields[0] != (Field{"key", "user/eric/${filename}", "hidden"}) {
+		t.Errorf("The key should be the prefix followed by ${filename}: %+v", form.Fields[0])
+	}
+	if form.Fields[2] != (Field{"Content-Type", "text/", "text"}) {
+		t.Errorf("A starts-with field should be a text input: %+v", form.Fields[2])
+	}
+	if form.Fields[5].Value != "foobar" {
+		t.Errorf("Unexpected AWSAccessKeyId: %+v", form.Fields[5])
+	}
+}
+
+func TestNewSignatureV4(t *testing.T) {
+	form, ok := New(parseFormPolicy(t), test_credentials, WithKey("user/eric/report.txt"),
+		WithTransportOptions(transport.WithSignatureVersion(transport.SignatureV4), transport.WithRegion("us-west-2"),
+			transport.WithClock(func() time.Time { return form_date }), transport.WithPathStyle()))
+	if ok != nil {
+		t.Fatalf("Unable to generate the form: %s", ok)
+	}
+	if form.Action != "https://s3.us-west-2.amazonaws.com/johnsmith/" {
+		t.Errorf("Unexpected action: %s", form.Action)
+	}
+	names := fieldNames(form.Fields)
+	if names[0] != "key" || form.Fields[0].Value != "user/eric/report.txt" {
+		t.Errorf("WithKey should set the key: %+v", form.Fields[0])
+	}
+	for _, name := range []string{"x-amz-algorithm", "x-amz-credential", "x-amz-date"} {
+		found := false
+		for _, field := range form.Fields {
+			found = found || field.Name == name && field.Type == "hidden"
+		}
+		if !found {
+			t.Errorf("Missing the signing field %s: %v", name, names)
+		}
+	}
+	if last := names[len(names)-2:]; !reflect.DeepEqual(last, []string{"policy", "x-amz-signature"}) {
+		t.Errorf("The signature should follow the other fields: %v", names)
+	}
+}
+
+func TestRenderUploads(t *testing.T) {
+	form, ok := New(parseFormPolicy(t), test_credentials, WithTitle("Holiday <photos>"))
+	if ok != nil {
+		t.Fatalf("Unable to generate the form: %s", ok)
+	}
+	var page bytes.Buffer
+	if ok = form.Render(&page, nil); ok != nil {
+		t.Fatalf("Unable to render the form: %s", ok)
+	}
+	html := page.String()
+	if !strings.Contains(html, "<title>Holiday &lt;photos&gt;</title>") {
+		t.Errorf("The title should be escaped: %s", html)
+	}
+	if file, submit := strings.Index(html, `type="file"`), strings.LastIndex(html, `name="signature"`); file < submit {
+		t.Errorf("The file input should follow every field: %s", html)
+	}
+
+	forms, ok := formscrape.Scrape(&page, nil)
+	if ok != nil {
+		t.Fatalf("Unable to scrape the rendered form: %s", ok)
+	}
+	server := s3test.NewServer(
+		s3test.WithCredentials(credentials.Value{AccessKeyId: "foobar", SecretAccessKey: "barfoo"}),
+		s3test.WithClock(func() time.Time { return form_date }))
+	defer server.Close()
+
+	fields := forms[0].Fields
+	for i := range fields {
+		if fields[i].Name == "Content-Type" {
+			fields[i].Value = "text/plain"
+		}
+	}
+	uploader, ok := transport.NewSingleFileUploader(bytes.NewReader(forms[0].RawPolicy), "file1.ext", strings.NewReader("file contents"),
+		transport.WithPresignedForm(forms[0].Action, fields), transport.WithHTTPClient(server.Client()))
+	if ok != nil {
+		t.Fatalf("Unable to create an uploader: %s", ok)
+	}
+	if _, ok = uploader.Upload(context.Background()); ok != nil {
+		t.Fatalf("The rendered form should upload: %s", ok)
+	}
+	object, found := server.Object("johnsmith", "user/eric/file1.ext")
+	if !found {
+		t.Fatalf("Object not stored: %v", server.Objects())
+	}
+	if contents, _ := object.Contents(); string(contents) != "file contents" {
+		t.Errorf("Unexpected contents: %q", contents)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	form, _ := New(parseFormPolicy(t), test_credentials)
+	tmpl := template.Must(template.New("custom").Parse(`{{.Action}}{{range .Fields}} {{.Name}}{{end}}`))
+	var page bytes.Buffer
+	if ok := form.Render(&page, tmpl); ok != nil {
+		t.Fatalf("Unable to render the form: %s", ok)
+	}
+	if expected := "https://johnsmith.s3.amazonaws.com/ key acl Content-Type success_action_status x-amz-meta-uuid AWSAccessKeyId policy signature"; page.String() != expected {
+		t.Errorf("Unexpected output.  Expected:\n%s\nActual:\n%s", expected, page.String())
+	}
+}
+
+func TestFormJSON(t *testing.T) {
+	form, _ := New(parseFormPolicy(t), test_credentials)
+	b, ok := json.Marshal(form)
+	if ok != nil {
+		t.Fatalf("Unable to marshal the form: %s", ok)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(b, &decoded)
+	if decoded["action"] != "https://johnsmith.s3.amazonaws.com/" || decoded["file"] != "file" || decoded["expiration"] != "2007-12-01T12:00:00Z" {
+		t.Errorf("Unexpected JSON: %s", b)
+	}
+	first := decoded["fields"].([]interface{})[0].(map[string]interface{})
+	if first["name"] != "key" || first["value"] != "user/eric/${filename}" || first["type"] != "hidden" {
+		t.Errorf("Unexpected first field: %v", first)
+	}
+}
+
+func TestDegenerateNew(t *testing.T) {
+	noKey, _ := policy.NewPolicy(form_date.Add(time.Hour))
+	noKey.AddConditionEq("bucket", "johnsmith")
+	if _, ok := New(noKey, test_credentials); ok == nil {
+		t.Errorf("A policy without a $key condition needs WithKey")
+	}
+	if _, ok := New(parseFormPolicy(t), test_credentials, WithTransportOptions(transport.WithEndpoint("://bad"))); ok == nil {
+		t.Errorf("An invalid endpoint should be an error")
+	}
+	if _, ok := New(parseFormPolicy(t), credentials.Value{AccessKeyId: "foobar", SecretAccessKey: "barfoo", SessionToken: "token"}); ok == nil {
+		t.Errorf("Temporary credentials should need an x-amz-security-token condition to sign with Signature Version 2")
+	}
+	if _, ok := New(parseFormPolicy(t), test_credentials, WithTransportOptions(transport.WithSignatureVersion(3))); ok == nil {
+		t.Errorf("An unsupported signature version should be an error")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Package formscrape extracts pre-signed S3 POST forms from HTML pages.
+
+A page that offers browser uploads to S3 already holds a signed policy in
+the hidden inputs of its form.  Scrape finds those forms so a file can be
+uploaded with the page's policy and signature, without local credentials.
+*/
+package formscrape
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+/*
+ErrNoForm is returned when a page holds no S3 POST form.
+*/
+var ErrNoForm = errors.New("No S3 upload form found on the page.")
+
+/*
+Form is a pre-signed S3 POST form.  Fields are the inputs S3 reads, in page
+order: the file input, the buttons and every input after the file, which S3
+ignores, are not included.
+*/
+type Form struct {
+	Action    string
+	Fields    []transport.FormField
+	Policy    *policy.Policy
+	RawPolicy []byte
+
+	fileSeen bool
+}
+
+/*
+Field returns the value of the named input.  Input names are compared
+without regard to case as S3 does.
+*/
+func (f *Form) Field(name string) (value string, found bool) {
+	for _, field := range f.Fields {
+		if strings.EqualFold(field.Name, name) {
+			return field.Value, true
+		}
+	}
+	return "", false
+}
+
+var s3Host = regexp.MustCompile(`(^|\.)s3([.-][a-z0-9-]+)*\.amazonaws\.com(\.cn)?$`)
+
+/*
+IsS3Endpoint reports whether u addresses Amazon S3.
+*/
+func IsS3Endpoint(u *url.URL) bool {
+	return s3Host.MatchString(strings.ToLower(u.Hostname()))
+}
+
+/*
+IsHTML reports whether a fetched document is an HTML page rather than a
+policy document.
+*/
+func IsHTML(raw []byte) bool {
+	return strings.HasPrefix(http.DetectContentType(raw), "text/html")
+}
+
+/*
+Scrape returns every form in the page whose action is an S3 endpoint and
+that carries a policy.  Relative actions are resolved against base.
+*/
+func Scrape(page io.Reader, base *url.URL) (forms []*Form, ok error) {
+	tokens, ok := newTokenizer(page)
+	if ok != nil {
+		return nil, ok
+	}
+
+	var current *Form
+	for {
+		t, ok := tokens.next()
+		if ok == io.EOF {
+			break
+		}
+		if ok != nil {
+			return nil, ok
+		}
+		switch {
+		case t.name == "form" && !t.end:
+			current = newForm(t, base)
+		case t.name == "input" && !t.end:
+			if current != nil {
+				current.addInput(t)
+			}
+		case t.name == "form" && t.end:
+			if current != nil {
+				if form, accepted := current.complete(); accepted {
+					forms = append(forms, form)
+				}
+				current = nil
+			}
+		}
+	}
+	if current != nil {
+		if form, accepted := current.complete(); accepted {
+			forms = append(forms, form)
+		}
+	}
+
+	if len(forms) == 0 {
+		return nil, ErrNoForm
+	}
+	return forms, nil
+}
+
+func newForm(element tag, base *url.URL) *Form {
+	action, _ := element.attr("action")
+	actionURL, ok := url.Parse(strings.TrimSpace(action))
+	if ok != nil {
+		return &Form{}
+	}
+	if base != nil {
+		actionURL = base.ResolveReference(actionURL)
+	}
+	return &Form{Action: actionURL.String()}
+}
+
+/*
+buttonTypes are inputs that submit or reset the form rather than hold a
+field.
+*/
+var buttonTypes = map[string]bool{"submit": true, "button": true, "reset": true, "image": true}
+
+func (f *Form) addInput(element tag) {
+	if f.fileSeen {
+		return
+	}
+	name, found := element.attr("name")
+	kind, _ := element.attr("type")
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind == "file" || strings.EqualFold(name, "file") {
+		f.fileSeen = true
+		return
+	}
+	if !found || name == "" || buttonTypes[kind] {
+		return
+	}
+	value, _ := element.attr("value")
+	f.Fields = append(f.Fields, transport.FormField{Name: name, Value: value})
+}
+
+/*
+complete decodes the policy of a form posting to S3.  Forms that post
+elsewhere or carry no valid policy are not upload forms.
+*/
+func (f *Form) complete() (form *Form, accepted bool) {
+	action, ok := url.Parse(f.Action)
+	if ok != nil || f.Action == "" || !IsS3Endpoint(action) {
+		return nil, false
+	}
+	encoded, found := f.Field("policy")
+	if !found {
+		return nil, false
+	}
+	if f.RawPolicy, ok = base64.StdEncoding.DecodeString(strings.TrimSpace(encoded)); ok != nil {
+		return nil, false
+	}
+	if f.Policy, ok = policy.ParsePolicy(f.RawPolicy); ok != nil {
+		return nil, false
+	}
+	return f, true
+}
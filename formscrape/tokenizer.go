@@ -0,0 +1,216 @@
+package formscrape
+
+import (
+	"html"
+	"io"
+	"strings"
+)
+
+/*
+tag is a start or end tag of an HTML page.  Attribute values are unescaped;
+when an attribute is repeated the first value is kept, as browsers do.
+*/
+type tag struct {
+	name  string
+	end   bool
+	attrs []attribute
+}
+
+type attribute struct {
+	name, value string
+}
+
+func (t tag) attr(name string) (value string, found bool) {
+	for _, a := range t.attrs {
+		if a.name == name {
+			return a.value, true
+		}
+	}
+	return "", false
+}
+
+/*
+rawTextElements hold text up to their end tag, so a < in a script is not
+read as the start of a tag.
+*/
+var rawTextElements = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"textarea": true,
+	"title":    true,
+	"xmp":      true,
+	"iframe":   true,
+	"noembed":  true,
+	"noframes": true,
+}
+
+/*
+tokenizer reads the tags of an HTML page the way browsers do for the parts
+Scrape needs: names are case insensitive, attribute values may be quoted or
+not, and text, comments and doctypes are skipped.
+*/
+type tokenizer struct {
+	page string
+	pos  int
+}
+
+func newTokenizer(page io.Reader) (*tokenizer, error) {
+	raw, ok := io.ReadAll(page)
+	if ok != nil {
+		return nil, ok
+	}
+	return &tokenizer{page: string(raw)}, nil
+}
+
+/*
+next returns the next tag of the page, or io.EOF after the last one.
+*/
+func (z *tokenizer) next() (t tag, ok error) {
+	for {
+		open := strings.IndexByte(z.page[z.pos:], '<')
+		if open < 0 {
+			z.pos = len(z.page)
+			return t, io.EOF
+		}
+		z.pos += open + 1
+		rest := z.page[z.pos:]
+		switch {
+		case strings.HasPrefix(rest, "!--"):
+			z.skipPast(rest[3:], "-->", 3)
+		case strings.HasPrefix(rest, "!") || strings.HasPrefix(rest, "?"):
+			z.skipPast(rest, ">", 0)
+		case strings.HasPrefix(rest, "/") && len(rest) > 1 && isLetter(rest[1]):
+			z.pos++
+			t = tag{name: z.readName(), end: true}
+			z.skipPast(z.page[z.pos:], ">", 0)
+			return t, nil
+		case len(rest) > 0 && isLetter(rest[0]):
+			t = tag{name: z.readName()}
+			t.attrs = z.readAttributes()
+			if rawTextElements[t.name] {
+				z.skipRawText(t.name)
+			}
+			return t, nil
+		}
+	}
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+/*
+skipPast moves past the first end in rest, which starts offset bytes after
+the current position, or to the end of the page when there is none.
+*/
+func (z *tokenizer) skipPast(rest, end string, offset int) {
+	if i := strings.Index(rest, end); i >= 0 {
+		z.pos += offset + i + len(end)
+	} else {
+		z.pos = len(z.page)
+	}
+}
+
+func (z *tokenizer) readName() string {
+	start := z.pos
+	for z.pos < len(z.page) {
+		c := z.page[z.pos]
+		if isSpace(c) || c == '/' || c == '>' {
+			break
+		}
+		z.pos++
+	}
+	return strings.ToLower(z.page[start:z.pos])
+}
+
+func (z *tokenizer) skipSpace() {
+	for z.pos < len(z.page) && isSpace(z.page[z.pos]) {
+		z.pos++
+	}
+}
+
+/*
+readAttributes reads up to and past the > closing a start tag.
+*/
+func (z *tokenizer) readAttributes() (attrs []attribute) {
+	for {
+		for z.pos < len(z.page) && (isSpace(z.page[z.pos]) || z.page[z.pos] == '/') {
+			z.pos++
+		}
+		if z.pos >= len(z.page) {
+			return attrs
+		}
+		if z.page[z.pos] == '>' {
+			z.pos++
+			return attrs
+		}
+
+		start := z.pos
+		for z.pos < len(z.page) {
+			c := z.page[z.pos]
+			if isSpace(c) || c == '/' || c == '>' || c == '=' && z.pos > start {
+				break
+			}
+			z.pos++
+		}
+		a := attribute{name: strings.ToLower(z.page[start:z.pos])}
+
+		z.skipSpace()
+		if z.pos < len(z.page) && z.page[z.pos] == '=' {
+			z.pos++
+			z.skipSpace()
+			a.value = html.UnescapeString(z.readValue())
+		}
+		attrs = append(attrs, a)
+	}
+}
+
+/*
+readValue reads a quoted value, or an unquoted one up to the next space or
+>.  An unquoted value may hold slashes, as in action=https://host/.
+*/
+func (z *tokenizer) readValue() string {
+	if z.pos >= len(z.page) {
+		return ""
+	}
+	if quote := z.page[z.pos]; quote == '"' || quote == '\'' {
+		z.pos++
+		start := z.pos
+		if i := strings.IndexByte(z.page[start:], quote); i >= 0 {
+			z.pos += i + 1
+			return z.page[start : start+i]
+		}
+		z.pos = len(z.page)
+		return z.page[start:]
+	}
+	start := z.pos
+	for z.pos < len(z.page) && !isSpace(z.page[z.pos]) && z.page[z.pos] != '>' {
+		z.pos++
+	}
+	return z.page[start:z.pos]
+}
+
+/*
+skipRawText moves to the end tag of a raw text element.  The text is not
+needed and may hold anything, including < and quotes.
+*/
+func (z *tokenizer) skipRawText(name string) {
+	for {
+		open := strings.Index(z.page[z.pos:], "</")
+		if open < 0 {
+			z.pos = len(z.page)
+			return
+		}
+		z.pos += open
+		end := z.pos + 2 + len(name)
+		if end <= len(z.page) && strings.EqualFold(z.page[z.pos+2:end], name) &&
+			(end == len(z.page) || isSpace(z.page[end]) || z.page[end] == '/' || z.page[end] == '>') {
+			return
+		}
+		z.pos += 2
+	}
+}
@@ -0,0 +1,351 @@
+/*
+Package yaml reads the subset of YAML used by policy templates.
+*/
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+MaxDepth is how deeply mappings and sequences may be nested, so a hostile
+document can not exhaust the stack.
+*/
+const MaxDepth = 32
+
+/*
+Parse reads block mappings and sequences by indentation, flow sequences and
+mappings such as [starts-with, $key, uploads/] and {bucket: johnsmith},
+comments, and plain, single and double quoted scalars.  Scalars are always
+strings; the caller decides what they mean.  Anchors, tags, multi-line
+scalars and multiple documents are not supported.
+*/
+func Parse(doc []byte) (value interface{}, ok error) {
+	y := &parser{}
+	for number, line := range strings.Split(strings.ReplaceAll(string(doc), "\r\n", "\n"), "\n") {
+		text := stripComment(line)
+		if strings.TrimSpace(text) == "" || strings.TrimSpace(text) == "---" {
+			continue
+		}
+		if strings.Contains(text, "\t") && strings.TrimLeft(text, " ")[0] == '\t' {
+			return nil, fmt.Errorf("Line %d: YAML must be indented with spaces, not tabs.", number+1)
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+		y.lines = append(y.lines, sourceLine{number + 1, indent, strings.TrimSpace(text)})
+	}
+	if len(y.lines) == 0 {
+		return nil, fmt.Errorf("The document is empty.")
+	}
+	if value, ok = y.block(y.lines[0].indent); ok != nil {
+		return nil, ok
+	}
+	if y.pos < len(y.lines) {
+		return nil, y.errorf(y.lines[y.pos], "Unexpected indentation.")
+	}
+	return value, nil
+}
+
+type sourceLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type parser struct {
+	lines []sourceLine
+	pos   int
+	depth int
+}
+
+func (y *parser) errorf(line sourceLine, format string, args ...interface{}) error {
+	return fmt.Errorf("Line %d: %s", line.number, fmt.Sprintf(format, args...))
+}
+
+/*
+stripComment removes a # comment that is not inside quotes.
+*/
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' '):
+			return strings.TrimRight(line[:i], " ")
+		}
+	}
+	return strings.TrimRight(line, " ")
+}
+
+/*
+block reads the mapping or sequence whose lines are indented by indent.
+*/
+func (y *parser) block(indent int) (interface{}, error) {
+	line := y.lines[y.pos]
+	if y.depth++; y.depth > MaxDepth {
+		return nil, y.errorf(line, "Nested more than %d deep.", MaxDepth)
+	}
+	defer func() { y.depth-- }()
+	if line.text == "-" || strings.HasPrefix(line.text, "- ") {
+		return y.sequence(indent)
+	}
+	return y.mapping(indent, nil)
+}
+
+func (y *parser) sequence(indent int) (interface{}, error) {
+	list := []interface{}{}
+	for y.pos < len(y.lines) {
+		line := y.lines[y.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent || !(line.text == "-" || strings.HasPrefix(line.text, "- ")) {
+			return nil, y.errorf(line, "Expected a sequence item starting with -.")
+		}
+		item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		y.pos++
+
+		var value interface{}
+		var ok error
+		switch {
+		case item == "":
+			if y.pos >= len(y.lines) || y.lines[y.pos].indent <= indent {
+				value = ""
+			} else {
+				value, ok = y.block(y.lines[y.pos].indent)
+			}
+		case isMappingEntry(item):
+			// - key: value, with any further keys indented to line up with key.
+			value, ok = y.mapping(indent+len(line.text)-len(item), &sourceLine{line.number, indent + len(line.text) - len(item), item})
+		default:
+			value, ok = parseFlow(item)
+			if ok != nil {
+				ok = y.errorf(line, "%s", ok)
+			}
+		}
+		if ok != nil {
+			return nil, ok
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+/*
+mapping reads key: value lines indented by indent.  first is a line that has
+already been consumed, the first entry of a mapping inside a sequence item.
+*/
+func (y *parser) mapping(indent int, first *sourceLine) (interface{}, error) {
+	values := map[string]interface{}{}
+	for {
+		var line sourceLine
+		if first != nil {
+			line, first = *first, nil
+		} else {
+			if y.pos >= len(y.lines) || y.lines[y.pos].indent < indent {
+				break
+			}
+			line = y.lines[y.pos]
+			if line.indent > indent {
+				return nil, y.errorf(line, "Unexpected indentation.")
+			}
+			y.pos++
+		}
+		if !isMappingEntry(line.text) {
+			return nil, y.errorf(line, "Expected key: value.")
+		}
+
+		key, rest := splitMappingEntry(line.text)
+		name, ok := parseScalar(key)
+		if ok != nil {
+			return nil, y.errorf(line, "%s", ok)
+		}
+		if _, duplicate := values[name]; duplicate {
+			return nil, y.errorf(line, "Duplicate key %q.", name)
+		}
+
+		var value interface{}
+		switch {
+		case rest != "":
+			if value, ok = parseFlow(rest); ok != nil {
+				return nil, y.errorf(line, "%s", ok)
+			}
+		case y.pos < len(y.lines) && y.lines[y.pos].indent > indent:
+			if value, ok = y.block(y.lines[y.pos].indent); ok != nil {
+				return nil, ok
+			}
+		case y.pos < len(y.lines) && y.lines[y.pos].indent == indent && strings.HasPrefix(y.lines[y.pos].text, "- "):
+			// Sequences may be indented as far as their key.
+			if value, ok = y.sequence(indent); ok != nil {
+				return nil, ok
+			}
+		default:
+			value = ""
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+/*
+isMappingEntry reports whether text is key: value, as opposed to a
+scalar that happens to contain a colon such as a URL.
+*/
+func isMappingEntry(text string) bool {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return false
+	}
+	key, _ := splitMappingEntry(text)
+	return key != ""
+}
+
+func splitMappingEntry(text string) (key, rest string) {
+	start := 0
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		if end := strings.IndexByte(text[1:], text[0]); end >= 0 {
+			start = end + 2
+		}
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+		}
+	}
+	return "", text
+}
+
+/*
+parseFlow reads a scalar or a flow sequence or mapping on one line.
+*/
+func parseFlow(text string) (value interface{}, ok error) {
+	f := &flow{text: text}
+	if value, ok = f.value(); ok != nil {
+		return nil, ok
+	}
+	if f.skipSpace(); f.pos < len(f.text) {
+		return nil, fmt.Errorf("Unexpected %q.", f.text[f.pos:])
+	}
+	return value, nil
+}
+
+type flow struct {
+	text  string
+	pos   int
+	depth int
+}
+
+func (f *flow) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *flow) value() (interface{}, error) {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return "", nil
+	}
+	switch f.text[f.pos] {
+	case '[':
+		return f.collection(']')
+	case '{':
+		return f.collection('}')
+	}
+
+	// A scalar runs to the end of the line or, inside a collection, the next , ] or }.
+	start := f.pos
+	var quote byte
+	for ; f.pos < len(f.text); f.pos++ {
+		c := f.text[f.pos]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if f.pos == start && (c == '"' || c == '\'') {
+			quote = c
+			continue
+		}
+		if f.depth > 0 && (c == ',' || c == ']' || c == '}' || c == ':' && (f.pos+1 == len(f.text) || f.text[f.pos+1] == ' ')) {
+			break
+		}
+	}
+	return parseScalar(strings.TrimSpace(f.text[start:f.pos]))
+}
+
+func (f *flow) collection(end byte) (interface{}, error) {
+	f.pos++
+	if f.depth++; f.depth > MaxDepth {
+		return nil, fmt.Errorf("Nested more than %d deep.", MaxDepth)
+	}
+	defer func() { f.depth-- }()
+	list := []interface{}{}
+	values := map[string]interface{}{}
+	for {
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == end {
+			f.pos++
+			break
+		}
+		item, ok := f.value()
+		if ok != nil {
+			return nil, ok
+		}
+		if end == '}' {
+			f.skipSpace()
+			if f.pos >= len(f.text) || f.text[f.pos] != ':' {
+				return nil, fmt.Errorf("Expected key: value in a flow mapping.")
+			}
+			f.pos++
+			value, ok := f.value()
+			if ok != nil {
+				return nil, ok
+			}
+			key, isString := item.(string)
+			if !isString {
+				return nil, fmt.Errorf("A flow mapping key must be a scalar.")
+			}
+			values[key] = value
+		} else {
+			list = append(list, item)
+		}
+
+		f.skipSpace()
+		if f.pos >= len(f.text) {
+			return nil, fmt.Errorf("Missing %q.", string(end))
+		}
+		if f.text[f.pos] == ',' {
+			f.pos++
+		} else if f.text[f.pos] != end {
+			return nil, fmt.Errorf("Expected , or %q, not %q.", string(end), f.text[f.pos:])
+		}
+	}
+	if end == '}' {
+		return values, nil
+	}
+	return list, nil
+}
+
+func parseScalar(text string) (string, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		value, ok := strconv.Unquote(text)
+		if ok != nil {
+			return "", fmt.Errorf("Invalid double quoted string %s.", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return "", fmt.Errorf("Invalid single quoted string %s.", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	}
+	return text, nil
+}
@@ -0,0 +1,82 @@
+package yaml
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const test_document = `# Uploads into a user's own directory.
+expires_in: 15m
+variables:
+  prefix: 'uploads/'
+conditions:
+  - bucket: ${bucket}
+  - [starts-with, $key, "${prefix}${user}/"]
+  - {acl: private}
+  -
+    - content-length-range
+    - 1
+    - 10MB
+`
+
+func TestParse(t *testing.T) {
+	value, ok := Parse([]byte(test_document))
+	if ok != nil {
+		t.Fatalf("Unable to parse the document: %s", ok)
+	}
+	expected := map[string]interface{}{
+		"expires_in": "15m",
+		"variables":  map[string]interface{}{"prefix": "uploads/"},
+		"conditions": []interface{}{
+			map[string]interface{}{"bucket": "${bucket}"},
+			[]interface{}{"starts-with", "$key", "${prefix}${user}/"},
+			map[string]interface{}{"acl": "private"},
+			[]interface{}{"content-length-range", "1", "10MB"},
+		},
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("Unexpected value.\nExpected: %#v\nActual: %#v", expected, value)
+	}
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	flow := strings.Repeat("[", MaxDepth) + strings.Repeat("]", MaxDepth)
+	if _, ok := Parse([]byte("a: " + flow)); ok != nil {
+		t.Errorf("%d nested flow collections should parse: %s", MaxDepth, ok)
+	}
+}
+
+func TestDegenerateParseNesting(t *testing.T) {
+	var block strings.Builder
+	for i := 0; i <= MaxDepth; i++ {
+		block.WriteString(strings.Repeat(" ", i) + "a:\n")
+	}
+	for name, doc := range map[string]string{
+		"flow sequences": "a: " + strings.Repeat("[", 100000),
+		"flow mappings":  "a: " + strings.Repeat("{a: ", 100000),
+		"block mappings": block.String(),
+	} {
+		if _, ok := Parse([]byte(doc)); ok == nil || !strings.Contains(ok.Error(), "Nested more than") {
+			t.Errorf("%s: Expected the nesting to be rejected, got: %v", name, ok)
+		}
+	}
+}
+
+func TestDegenerateParse(t *testing.T) {
+	for doc, message := range map[string]string{
+		``:                  "empty",
+		"a:\n\t- b":         "spaces, not tabs",
+		"a: [b, c":          "Missing",
+		"a: {b}":            "key: value",
+		"a: b\nb: c\na: d":  "Duplicate key",
+		"a: \"unterminated": "double quoted",
+		"a: 'unterminated":  "single quoted",
+		"- a\nb: c":         "Line 2",
+		"a: [b] c":          "Unexpected",
+	} {
+		if _, ok := Parse([]byte(doc)); ok == nil || !strings.Contains(ok.Error(), message) {
+			t.Errorf("Expected an error containing %q for %q, got: %v", message, doc, ok)
+		}
+	}
+}
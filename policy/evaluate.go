@@ -0,0 +1,123 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Violation describes one way an upload fails to satisfy a policy.  Field is
+the form field concerned, Condition is nil for fields the policy does not
+mention.
+*/
+type Violation struct {
+	Field     string
+	Condition Condition
+	Message   string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", v.Field, v.Message)
+}
+
+/*
+EvaluationError lists every violation found by Evaluate.
+*/
+type EvaluationError struct {
+	Violations []Violation
+}
+
+func (e *EvaluationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.String()
+	}
+	return "Upload does not satisfy the policy: " + strings.Join(messages, "; ")
+}
+
+/*
+unconditionedFields may be sent without appearing in the policy.
+
+http://docs.aws.amazon.com/AmazonS3/latest/dev/HTTPPOSTForms.html#HTTPPOSTConstructPolicy
+*/
+var unconditionedFields = map[string]bool{
+	"awsaccesskeyid":  true,
+	"signature":       true,
+	"x-amz-signature": true,
+	"file":            true,
+	"policy":          true,
+}
+
+func isUnconditioned(field string) bool {
+	return unconditionedFields[field] || strings.HasPrefix(field, "x-ignore-")
+}
+
+/*
+Evaluate checks a proposed upload the way S3 does: every condition must be
+satisfied by the form fields, content-length-range by contentLength, and
+every form field must be covered by a condition.  S3 takes the bucket from
+the URL rather than the form, so callers include it in fields as bucket; a
+bucket condition is violated when it is missing.  A nil error means S3
+should accept the upload.
+*/
+func (p *Policy) Evaluate(fields map[string]string, contentLength int64) error {
+	values := make(map[string]string, len(fields))
+	for name, value := range fields {
+		values[FieldName(name)] = value
+	}
+
+	var violations []Violation
+	covered := map[string]bool{}
+	for _, condition := range p.Conditions {
+		field := FieldName(condition.Name())
+		covered[field] = true
+
+		if _, isRange := condition.(ConditionRange); isRange {
+			if field != "content-length-range" {
+				violations = append(violations, Violation{condition.Name(), condition, "unsupported range condition"})
+				continue
+			}
+			if !condition.Matches(condition.Name(), strconv.FormatInt(contentLength, 10)) {
+				violations = append(violations, Violation{condition.Name(), condition,
+					fmt.Sprintf("content length %d is outside of %s", contentLength, condition.ValueString())})
+			}
+			continue
+		}
+
+		value, found := values[field]
+		if !found {
+			violations = append(violations, Violation{condition.Name(), condition, "required by the policy but missing"})
+			continue
+		}
+		if !condition.Matches(condition.Name(), value) {
+			violations = append(violations, Violation{condition.Name(), condition, mismatch(condition, value)})
+		}
+	}
+
+	var uncovered []string
+	for field := range values {
+		if !covered[field] && !isUnconditioned(field) {
+			uncovered = append(uncovered, field)
+		}
+	}
+	sort.Strings(uncovered)
+	for _, field := range uncovered {
+		violations = append(violations, Violation{field, nil, "not covered by any condition in the policy"})
+	}
+
+	if violations != nil {
+		return &EvaluationError{violations}
+	}
+	return nil
+}
+
+func mismatch(condition Condition, value string) string {
+	switch condition.(type) {
+	case ConditionStartsWith:
+		return fmt.Sprintf("%q does not start with %q", value, condition.ValueString())
+	default:
+		return fmt.Sprintf("%q does not equal %q", value, condition.ValueString())
+	}
+}
@@ -0,0 +1,365 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Severity ranks a Diagnostic.  S3 rejects uploads signed with a policy that
+has errors; warnings and notes describe policies that work but allow more
+than was probably intended.
+*/
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityNote    Severity = "note"
+)
+
+/*
+Diagnostic is one problem found by Lint.  Path is the JSONPath of the
+element concerned, e.g. $.conditions[1][0].
+*/
+type Diagnostic struct {
+	Path     string   `json:"path"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Path, d.Severity, d.Message)
+}
+
+/*
+HasErrors reports whether any of diagnostics is an error.
+*/
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+lintCondition is a condition as Lint reads it, with where it was found.
+*/
+type lintCondition struct {
+	path     string
+	operator string
+	name     string
+	value    string
+	min, max float64
+}
+
+func (c lintCondition) field() string {
+	return FieldName(c.name)
+}
+
+type linter struct {
+	diagnostics []Diagnostic
+	conditions  []lintCondition
+}
+
+func (l *linter) report(path string, severity Severity, format string, args ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{path, severity, fmt.Sprintf(format, args...)})
+}
+
+/*
+Lint checks a policy document for mistakes S3 would reject and for
+conditions that allow more than was probably intended.  It reads the
+document itself rather than a parsed Policy, so it describes documents
+ParsePolicy rejects, and never fails: an empty result is a clean policy.
+now is compared with the expiration.
+*/
+func Lint(doc []byte, now time.Time) []Diagnostic {
+	l := &linter{}
+
+	var root map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if ok := decoder.Decode(&root); ok != nil || root == nil {
+		l.report("$", SeverityError, "The policy is not a JSON object: %s", describeJSONError(ok))
+		return l.diagnostics
+	}
+
+	l.lintExpiration(member(root, "expiration"), now)
+
+	conditions, isList := member(root, "conditions").([]interface{})
+	switch {
+	case member(root, "conditions") == nil:
+		l.report("$.conditions", SeverityError, "Missing conditions element.")
+		return l.diagnostics
+	case !isList:
+		l.report("$.conditions", SeverityError, "The conditions must be an array.")
+		return l.diagnostics
+	}
+	for i, condition := range conditions {
+		l.lintCondition(fmt.Sprintf("$.conditions[%d]", i), condition)
+	}
+
+	l.lintConflicts()
+	l.lintCoverage()
+	return l.diagnostics
+}
+
+/*
+member looks up an element of the policy without regard to case, as the
+parser does.  An exact match is preferred.
+*/
+func member(object map[string]interface{}, name string) interface{} {
+	if value, found := object[name]; found {
+		return value
+	}
+	names := make([]string, 0, len(object))
+	for key := range object {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		if strings.EqualFold(key, name) {
+			return object[key]
+		}
+	}
+	return nil
+}
+
+func describeJSONError(ok error) string {
+	if ok == nil {
+		return "null."
+	}
+	return ok.Error()
+}
+
+func (l *linter) lintExpiration(value interface{}, now time.Time) {
+	const path = "$.expiration"
+	if value == nil {
+		l.report(path, SeverityError, "Missing expiration element.")
+		return
+	}
+	text, isString := value.(string)
+	if !isString {
+		l.report(path, SeverityError, "The expiration must be a string such as %q.", ExpirationFormat)
+		return
+	}
+	expiration, ok := time.Parse(time.RFC3339, text)
+	if ok != nil {
+		l.report(path, SeverityError, "Invalid expiration %q.  Expected a time such as %q.", text, ExpirationFormat)
+		return
+	}
+	if !expiration.After(now) {
+		l.report(path, SeverityError, "The policy expired at %s.", expiration.UTC().Format(time.RFC3339))
+	}
+}
+
+func (l *linter) lintCondition(path string, condition interface{}) {
+	switch condition := condition.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(condition))
+		for name := range condition {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			l.report(path, SeverityError, "An exact match condition must name a field.")
+		}
+		for _, name := range names {
+			value, isString := condition[name].(string)
+			if !isString {
+				l.report(memberPath(path, name), SeverityError, "The value of %s must be a string.", name)
+				continue
+			}
+			l.conditions = append(l.conditions, lintCondition{path: memberPath(path, name), operator: "eq", name: name, value: value})
+		}
+	case []interface{}:
+		l.lintArrayCondition(path, condition)
+	default:
+		l.report(path, SeverityError, "A condition must be an object or an array, not %s.", describeJSON(condition))
+	}
+}
+
+func (l *linter) lintArrayCondition(path string, condition []interface{}) {
+	if len(condition) != 3 {
+		l.report(path, SeverityError, "A condition array must have 3 elements, not %d.", len(condition))
+		return
+	}
+	operator, isString := condition[0].(string)
+	if !isString {
+		l.report(path+"[0]", SeverityError, "The condition operator must be a string, not %s.", describeJSON(condition[0]))
+		return
+	}
+	operator, ok := conditionOperator(operator)
+	if ok != nil {
+		l.report(path+"[0]", SeverityError, "%s", ok)
+		return
+	}
+
+	switch operator {
+	case "eq", "starts-with":
+		name, nameIsString := condition[1].(string)
+		value, valueIsString := condition[2].(string)
+		if !nameIsString {
+			l.report(path+"[1]", SeverityError, "The field of %s must be a string, not %s.", operator, describeJSON(condition[1]))
+			return
+		}
+		if !strings.HasPrefix(name, "$") {
+			l.report(path+"[1]", SeverityError, "The field of %s must start with $: use %q.", operator, "$"+name)
+			return
+		}
+		if !valueIsString {
+			l.report(path+"[2]", SeverityError, "The value of %s must be a string, not %s.", operator, describeJSON(condition[2]))
+			return
+		}
+		l.conditions = append(l.conditions, lintCondition{path: path, operator: operator, name: name, value: value})
+	case "content-length-range":
+		min, minOk := jsonNumber(condition[1])
+		max, maxOk := jsonNumber(condition[2])
+		switch {
+		case !minOk:
+			l.report(path+"[1]", SeverityError, "The minimum content length must be a number, not %s.", describeJSON(condition[1]))
+		case !maxOk:
+			l.report(path+"[2]", SeverityError, "The maximum content length must be a number, not %s.", describeJSON(condition[2]))
+		case min < 0:
+			l.report(path+"[1]", SeverityError, "The minimum content length can not be negative.")
+		case min > max:
+			l.report(path, SeverityError, "The minimum content length %v is greater than the maximum %v.", min, max)
+		default:
+			l.conditions = append(l.conditions, lintCondition{path: path, operator: operator, name: operator, min: min, max: max})
+		}
+	}
+}
+
+func jsonNumber(value interface{}) (n float64, ok bool) {
+	number, isNumber := value.(json.Number)
+	if !isNumber {
+		return 0, false
+	}
+	n, err := number.Float64()
+	return n, err == nil
+}
+
+func describeJSON(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(value)
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	case []interface{}:
+		return "an array"
+	case json.Delim:
+		if value == '[' {
+			return "an array"
+		}
+		return "an object"
+	default:
+		return "an object"
+	}
+}
+
+/*
+memberPath appends an object member to a JSONPath, quoting names that are
+not plain identifiers such as $key or Content-Type.
+*/
+func memberPath(path, name string) string {
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return fmt.Sprintf("%s[%s]", path, strconv.Quote(name))
+		}
+	}
+	return path + "." + name
+}
+
+/*
+lintConflicts compares every pair of conditions on the same field.  S3
+requires all of them to hold, so two that can not both hold make the
+policy unusable.
+*/
+func (l *linter) lintConflicts() {
+	for j, later := range l.conditions {
+		for _, earlier := range l.conditions[:j] {
+			if earlier.field() != later.field() {
+				continue
+			}
+			if earlier.operator == later.operator && earlier.value == later.value && earlier.min == later.min && earlier.max == later.max {
+				l.report(later.path, SeverityWarning, "Duplicates %s.", earlier.path)
+				break
+			}
+			if message := contradiction(earlier, later); message != "" {
+				l.report(later.path, SeverityError, "Contradicts %s: %s", earlier.path, message)
+				break
+			}
+		}
+	}
+}
+
+func contradiction(a, b lintCondition) string {
+	switch {
+	case a.operator == "content-length-range":
+		if a.max < b.min || b.max < a.min {
+			return fmt.Sprintf("no content length is within both %v-%v and %v-%v.", a.min, a.max, b.min, b.max)
+		}
+	case a.operator == "eq" && b.operator == "eq":
+		return fmt.Sprintf("%s can not equal both %q and %q.", b.name, a.value, b.value)
+	case a.operator == "eq" && !strings.HasPrefix(a.value, b.value):
+		return fmt.Sprintf("%s must equal %q, which does not start with %q.", b.name, a.value, b.value)
+	case b.operator == "eq" && !strings.HasPrefix(b.value, a.value):
+		return fmt.Sprintf("%s must equal %q, which does not start with %q.", b.name, b.value, a.value)
+	case a.operator == "starts-with" && b.operator == "starts-with" &&
+		!strings.HasPrefix(a.value, b.value) && !strings.HasPrefix(b.value, a.value):
+		return fmt.Sprintf("%s can not start with both %q and %q.", b.name, a.value, b.value)
+	}
+	return ""
+}
+
+/*
+lintCoverage checks the policy as a whole: the fields S3 requires, a limit
+on the size of uploads, and how much of the bucket it opens up.
+*/
+func (l *linter) lintCoverage() {
+	byField := map[string][]lintCondition{}
+	for _, condition := range l.conditions {
+		byField[condition.field()] = append(byField[condition.field()], condition)
+	}
+
+	for _, required := range []string{"bucket", "key"} {
+		if len(byField[required]) == 0 {
+			l.report("$.conditions", SeverityError, "Missing required field %s.", required)
+		}
+	}
+	if len(byField["content-length-range"]) == 0 {
+		l.report("$.conditions", SeverityWarning, "Missing content-length-range.  Uploads of any size, up to 5 GB, are allowed.")
+	}
+
+	for _, key := range byField["key"] {
+		if key.operator != "starts-with" {
+			continue
+		}
+		switch {
+		case strings.Trim(key.value, "/") == "":
+			l.report(key.path, SeverityWarning, "Any key in the bucket can be written, including existing objects.")
+		case !strings.HasSuffix(key.value, "/"):
+			l.report(key.path, SeverityNote, "The $key prefix %q does not end in /, so it also allows keys such as %q.", key.value, key.value+"-other/file")
+		}
+	}
+
+	for _, acl := range byField["acl"] {
+		switch {
+		case acl.operator == "eq" && strings.HasPrefix(acl.value, "public-read"):
+			l.report(acl.path, SeverityWarning, "Uploads are public: the %s ACL grants everyone access to the objects.", acl.value)
+		case acl.operator == "starts-with" && strings.HasPrefix("public-read", acl.value):
+			l.report(acl.path, SeverityWarning, "The uploader may choose a public ACL such as public-read.")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const lint_clean_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    {"acl": "private"},
+    ["starts-with", "$Content-Type", "image/"],
+    ["eq", "$Content-Type", "image/jpeg"],
+    ["content-length-range", 1, 1048576]
+  ]
+}`
+
+var lint_now = time.Date(2007, 11, 30, 0, 0, 0, 0, time.UTC)
+
+func lintPaths(diagnostics []Diagnostic) (paths []string) {
+	for _, diagnostic := range diagnostics {
+		paths = append(paths, string(diagnostic.Severity)+" "+diagnostic.Path)
+	}
+	return
+}
+
+func checkLint(t *testing.T, doc string, expected ...string) []Diagnostic {
+	t.Helper()
+	diagnostics := Lint([]byte(doc), lint_now)
+	if actual := lintPaths(diagnostics); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected diagnostics.  Expected: %v Actual: %v", expected, diagnostics)
+	}
+	return diagnostics
+}
+
+func TestLintCleanPolicy(t *testing.T) {
+	checkLint(t, lint_clean_policy)
+}
+
+func TestLintAWSExample(t *testing.T) {
+	diagnostics := checkLint(t, aws_example_file_upload_policy, "warning $.conditions", "warning $.conditions[2].acl")
+	if !strings.Contains(diagnostics[0].Message, "content-length-range") {
+		t.Errorf("The missing content-length-range should be named: %s", diagnostics[0])
+	}
+	if HasErrors(diagnostics) {
+		t.Errorf("The AWS example has no errors: %v", diagnostics)
+	}
+}
+
+func TestLintExpired(t *testing.T) {
+	doc := strings.Replace(lint_clean_policy, "2007-12-01", "2007-11-01", 1)
+	diagnostics := checkLint(t, doc, "error $.expiration")
+	if !HasErrors(diagnostics) || !strings.Contains(diagnostics[0].Message, "expired at 2007-11-01T12:00:00Z") {
+		t.Errorf("Unexpected diagnostic: %v", diagnostics)
+	}
+}
+
+func TestLintMalformedConditions(t *testing.T) {
+	checkLint(t, `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    ["content-length-range", 1, 1024],
+    ["ends-with", "$key", ".jpg"],
+    ["starts-with", "Content-Type", "image/"],
+    ["eq", "$acl"],
+    {"x-amz-meta-size": 10},
+    "acl",
+    ["content-length-range", 10, 1]
+  ]
+}`, "error $.conditions[3][0]", "error $.conditions[4][1]", "error $.conditions[5]", `error $.conditions[6]["x-amz-meta-size"]`,
+		"error $.conditions[7]", "error $.conditions[8]")
+}
+
+func TestLintDuplicateAndContradictoryConditions(t *testing.T) {
+	diagnostics := checkLint(t, `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    ["content-length-range", 1, 1024],
+    {"bucket": "johnsmith"},
+    ["eq", "$bucket", "janedoe"],
+    ["starts-with", "$key", "user/jane/"],
+    ["eq", "$key", "user/eric/photo.jpg"],
+    ["content-length-range", 2048, 4096]
+  ]
+}`, "warning $.conditions[3].bucket", "error $.conditions[4]", "error $.conditions[5]", "error $.conditions[6]", "error $.conditions[7]")
+	if !strings.Contains(diagnostics[0].Message, "$.conditions[0].bucket") {
+		t.Errorf("The duplicate should name the original: %s", diagnostics[0])
+	}
+}
+
+func TestLintBroadPolicy(t *testing.T) {
+	checkLint(t, `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", ""],
+    ["starts-with", "$acl", ""],
+    ["content-length-range", 1, 1024]
+  ]
+}`, "warning $.conditions[1]", "warning $.conditions[2]")
+	checkLint(t, strings.Replace(lint_clean_policy, "user/eric/", "user/eric", 1), "note $.conditions[1]")
+}
+
+func TestLintElementNamesIgnoreCase(t *testing.T) {
+	doc := strings.Replace(strings.Replace(lint_clean_policy, `"expiration"`, `"Expiration"`, 1), `"conditions"`, `"Conditions"`, 1)
+	checkLint(t, doc)
+	checkLint(t, strings.Replace(doc, "2007-12-01", "2007-11-01", 1), "error $.expiration")
+}
+
+func TestDegenerateLintMissingElements(t *testing.T) {
+	checkLint(t, `not json`, "error $")
+	checkLint(t, `null`, "error $")
+	checkLint(t, no_condition_policy, "error $.conditions")
+	checkLint(t, `{"expiration": 12, "conditions": [{"bucket": "b"}, ["content-length-range", 1, 2]]}`,
+		"error $.expiration", "error $.conditions")
+}
+
+func TestDegenerateParsePolicyNamesMissingField(t *testing.T) {
+	if _, ok := ParsePolicy([]byte(missing_conditions_bucket)); ok == nil || !strings.Contains(ok.Error(), "bucket") {
+		t.Errorf("The missing field should be named: %v", ok)
+	}
+}
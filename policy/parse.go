@@ -0,0 +1,319 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ParseError describes where a policy document is malformed.  Path is the
+JSONPath of the offending element, e.g. $.conditions[2][1], and Offset the
+byte offset at which it starts in the document.
+*/
+type ParseError struct {
+	Path    string
+	Offset  int64
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Invalid policy at %s (offset %d): %s", e.Path, e.Offset, e.Message)
+}
+
+/*
+policyParser reads a policy document token by token.  Policies are fetched
+from web pages and standard input, so every shape of JSON is checked before
+it is used and reported as a *ParseError rather than trusted.
+*/
+type policyParser struct {
+	doc     []byte
+	decoder *json.Decoder
+}
+
+func newPolicyParser(doc []byte) *policyParser {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	return &policyParser{doc, decoder}
+}
+
+func (d *policyParser) errorf(path string, offset int64, format string, args ...interface{}) error {
+	return &ParseError{path, offset, fmt.Sprintf(format, args...)}
+}
+
+/*
+start is the offset of the next token: the decoder reports the end of the
+previous one, before any separator.
+*/
+func (d *policyParser) start() int64 {
+	offset := d.decoder.InputOffset()
+	for offset < int64(len(d.doc)) && strings.IndexByte(" \t\r\n,:", d.doc[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+func (d *policyParser) next(path string) (token json.Token, offset int64, ok error) {
+	offset = d.start()
+	if token, ok = d.decoder.Token(); ok != nil {
+		var syntax *json.SyntaxError
+		switch {
+		case errors.As(ok, &syntax):
+			return nil, syntax.Offset, d.errorf(path, syntax.Offset, "%s.", syntax)
+		case ok == io.EOF:
+			return nil, offset, d.errorf(path, offset, "Unexpected end of the policy document.")
+		default:
+			return nil, offset, d.errorf(path, offset, "%s", ok)
+		}
+	}
+	return token, offset, nil
+}
+
+/*
+parse reads a whole policy into p, replacing its expiration and conditions.
+*/
+func (d *policyParser) parse(p *Policy) error {
+	token, start, ok := d.next("$")
+	if ok != nil {
+		return ok
+	}
+	if token != json.Delim('{') {
+		return d.errorf("$", start, "The policy must be a JSON object, not %s.", describeJSON(token))
+	}
+
+	var expiration *time.Time
+	var conditions []Condition
+	conditionsFound := false
+	conditionsOffset := start
+	for d.decoder.More() {
+		token, _, ok := d.next("$")
+		if ok != nil {
+			return ok
+		}
+		name, _ := token.(string)
+		path := memberPath("$", name)
+		switch {
+		case strings.EqualFold(name, "expiration"):
+			if expiration, ok = d.expiration(path); ok != nil {
+				return ok
+			}
+		case strings.EqualFold(name, "conditions"):
+			conditionsOffset = d.start()
+			if conditions, conditionsFound, ok = d.conditions(path); ok != nil {
+				return ok
+			}
+		default:
+			var ignored json.RawMessage
+			if ok = d.decoder.Decode(&ignored); ok != nil {
+				return d.errorf(path, d.start(), "%s", ok)
+			}
+		}
+	}
+	if _, _, ok = d.next("$"); ok != nil {
+		return ok
+	}
+
+	if expiration == nil {
+		return d.errorf("$", start, "Missing expiration element.")
+	}
+	if !conditionsFound {
+		return d.errorf("$", start, "Missing conditions element.")
+	}
+	p.Expiration = *expiration
+	p.Conditions = conditions
+	if ok = p.checkForRequiredFields(); ok != nil {
+		return d.errorf("$.conditions", conditionsOffset, "%s", ok)
+	}
+	return nil
+}
+
+/*
+end checks that nothing but white space follows the policy.
+*/
+func (d *policyParser) end() error {
+	offset := d.start()
+	if _, ok := d.decoder.Token(); ok != io.EOF {
+		return d.errorf("$", offset, "Unexpected data after the policy.")
+	}
+	return nil
+}
+
+func (d *policyParser) expiration(path string) (expiration *time.Time, ok error) {
+	token, offset, ok := d.next(path)
+	if ok != nil {
+		return nil, ok
+	}
+	switch value := token.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		parsed, ok := time.Parse(time.RFC3339, value)
+		if ok != nil {
+			return nil, d.errorf(path, offset, "Invalid expiration %q.  Expected a time such as %q.", value, ExpirationFormat)
+		}
+		return &parsed, nil
+	default:
+		return nil, d.errorf(path, offset, "The expiration must be a string, not %s.", describeJSON(token))
+	}
+}
+
+func (d *policyParser) conditions(path string) (conditions []Condition, found bool, ok error) {
+	token, offset, ok := d.next(path)
+	if ok != nil {
+		return nil, false, ok
+	}
+	if token == nil {
+		return nil, false, nil
+	}
+	if token != json.Delim('[') {
+		return nil, false, d.errorf(path, offset, "The conditions must be an array, not %s.", describeJSON(token))
+	}
+
+	p := &Policy{Conditions: []Condition{}}
+	for i := 0; d.decoder.More(); i++ {
+		element := fmt.Sprintf("%s[%d]", path, i)
+		token, offset, ok := d.next(element)
+		if ok != nil {
+			return nil, false, ok
+		}
+		switch token {
+		case json.Delim('{'):
+			ok = d.objectCondition(p, element)
+		case json.Delim('['):
+			ok = d.arrayCondition(p, element, offset)
+		default:
+			ok = d.errorf(element, offset, "A condition must be an object or an array, not %s.", describeJSON(token))
+		}
+		if ok != nil {
+			return nil, false, ok
+		}
+	}
+	if _, _, ok = d.next(path); ok != nil {
+		return nil, false, ok
+	}
+	return p.Conditions, true, nil
+}
+
+/*
+objectCondition reads exact matches such as {"bucket": "johnsmith"}.  An
+object with several fields is sorted so it parses the same way every time.
+*/
+func (d *policyParser) objectCondition(p *Policy, path string) error {
+	values := map[string]string{}
+	for d.decoder.More() {
+		token, _, ok := d.next(path)
+		if ok != nil {
+			return ok
+		}
+		name, _ := token.(string)
+		field := memberPath(path, name)
+		token, offset, ok := d.next(field)
+		if ok != nil {
+			return ok
+		}
+		value, isString := token.(string)
+		if !isString {
+			return d.errorf(field, offset, "The value of %s must be a string, not %s.", name, describeJSON(token))
+		}
+		values[name] = value
+	}
+	if _, _, ok := d.next(path); ok != nil {
+		return ok
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.AddConditionEq(name, values[name])
+	}
+	return nil
+}
+
+/*
+conditionOperator classifies the operator of a condition array.  S3 ignores
+its case and knows only eq, starts-with and content-length-range.
+*/
+func conditionOperator(operator string) (string, error) {
+	switch lower := strings.ToLower(operator); lower {
+	case "eq", "starts-with", "content-length-range":
+		return lower, nil
+	}
+	return "", fmt.Errorf("Unknown condition operator %q.  Expected eq, starts-with or content-length-range.", operator)
+}
+
+/*
+arrayCondition reads ["eq", "$field", "value"], ["starts-with", "$field",
+"prefix"] and ["content-length-range", 1, 10].
+*/
+func (d *policyParser) arrayCondition(p *Policy, path string, start int64) error {
+	var elements []json.Token
+	var offsets []int64
+	for d.decoder.More() {
+		element := fmt.Sprintf("%s[%d]", path, len(elements))
+		token, offset, ok := d.next(element)
+		if ok != nil {
+			return ok
+		}
+		if _, isDelim := token.(json.Delim); isDelim {
+			return d.errorf(element, offset, "A condition array may only hold strings and numbers, not %s.", describeJSON(token))
+		}
+		elements = append(elements, token)
+		offsets = append(offsets, offset)
+	}
+	if _, _, ok := d.next(path); ok != nil {
+		return ok
+	}
+
+	if len(elements) != 3 {
+		return d.errorf(path, start, "A condition array must have 3 elements, not %d.", len(elements))
+	}
+	operator, isString := elements[0].(string)
+	if !isString {
+		return d.errorf(path+"[0]", offsets[0], "The condition operator must be a string, not %s.", describeJSON(elements[0]))
+	}
+	operator, ok := conditionOperator(operator)
+	if ok != nil {
+		return d.errorf(path+"[0]", offsets[0], "%s", ok)
+	}
+
+	switch operator {
+	case "starts-with", "eq":
+		field, fieldIsString := elements[1].(string)
+		if !fieldIsString {
+			return d.errorf(path+"[1]", offsets[1], "The field of %s must be a string, not %s.", operator, describeJSON(elements[1]))
+		}
+		value, valueIsString := elements[2].(string)
+		if !valueIsString {
+			return d.errorf(path+"[2]", offsets[2], "The value of %s must be a string, not %s.", operator, describeJSON(elements[2]))
+		}
+		if operator == "eq" {
+			p.AddConditionEq(field, value)
+		} else if ok := p.AddConditionStartsWith(field, value); ok != nil {
+			return d.errorf(path+"[1]", offsets[1], "%s", ok)
+		}
+	case "content-length-range":
+		for i, bound := range []string{"minimum", "maximum"} {
+			if _, isNumber := elements[i+1].(json.Number); !isNumber {
+				return d.errorf(fmt.Sprintf("%s[%d]", path, i+1), offsets[i+1], "The %s of %s must be a number, not %s.", bound, operator, describeJSON(elements[i+1]))
+			}
+		}
+		min, minOk := jsonNumber(elements[1])
+		if !minOk {
+			return d.errorf(path+"[1]", offsets[1], "The minimum of %s is out of range.", operator)
+		}
+		max, maxOk := jsonNumber(elements[2])
+		if !maxOk {
+			return d.errorf(path+"[2]", offsets[2], "The maximum of %s is out of range.", operator)
+		}
+		p.AddConditionRange(operator, min, max)
+	}
+	return nil
+}
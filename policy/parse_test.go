@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func checkParseError(t *testing.T, doc, path, message string) {
+	t.Helper()
+	_, ok := ParsePolicy([]byte(doc))
+	var parseError *ParseError
+	if !errors.As(ok, &parseError) {
+		t.Fatalf("Expected a *ParseError for %s, got: %#v", doc, ok)
+	}
+	if parseError.Path != path || !strings.Contains(parseError.Message, message) {
+		t.Errorf("Unexpected error for %s: %s", doc, parseError)
+	}
+	if parseError.Offset < 0 || parseError.Offset > int64(len(doc)) {
+		t.Errorf("Offset %d is outside of the document", parseError.Offset)
+	}
+}
+
+func TestDegenerateParsePolicyMalformedShapes(t *testing.T) {
+	for _, test := range []struct{ doc, path, message string }{
+		{``, "$", "end of the policy"},
+		{`null`, "$", "must be a JSON object"},
+		{`[]`, "$", "must be a JSON object"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [{"bucket": "b", "$key": "k"}]} {}`, "$", "after the policy"},
+		{`{"expiration": 12, "conditions": []}`, "$.expiration", "must be a string"},
+		{`{"expiration": "tomorrow", "conditions": []}`, "$.expiration", `"tomorrow"`},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": {}}`, "$.conditions", "must be an array"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": ["bucket"]}`, "$.conditions[0]", `not "bucket"`},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [7]}`, "$.conditions[0]", "not 7"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [{"bucket": 7}]}`, "$.conditions[0].bucket", "must be a string"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [{"$key": ["k"]}]}`, `$.conditions[0]["$key"]`, "not an array"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [[]]}`, "$.conditions[0]", "3 elements, not 0"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["starts-with", "$key"]]}`, "$.conditions[0]", "3 elements, not 2"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [[1, 2, 3]]}`, "$.conditions[0][0]", "operator must be a string"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["starts-with", 1, "k"]]}`, "$.conditions[0][1]", "must be a string"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["eq", "$key", null]]}`, "$.conditions[0][2]", "not null"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["starts-with", "", "k"]]}`, "$.conditions[0][1]", "must start with $"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["ends-with", "$key", ".jpg"]]}`, "$.conditions[0][0]", `"ends-with"`},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["x-amz-meta-size", 1, 2]]}`, "$.conditions[0][0]", "Unknown condition operator"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["content-length-range", "1", 2]]}`, "$.conditions[0][1]", "must be a number"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["content-length-range", 1e999, 2]]}`, "$.conditions[0][1]", "out of range"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["content-length-range", [1], 2]]}`, "$.conditions[0][1]", "strings and numbers"},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [{"bucket": "b"}]}`, "$.conditions", "Missing required field $key."},
+		{`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [{"bucket": "b"}`, "$.conditions[1]", "end of JSON input"},
+	} {
+		checkParseError(t, test.doc, test.path, test.message)
+	}
+}
+
+func TestParsePolicyOperatorCase(t *testing.T) {
+	p, ok := ParsePolicy([]byte(`{"expiration": "2012-01-01T00:00:00.000Z",
+  "conditions": [{"bucket": "b"}, ["EQ", "$key", "k"], ["Content-Length-Range", 1, 10]]}`))
+	if ok != nil {
+		t.Fatalf("Operators should be case insensitive: %s", ok)
+	}
+	if condition, found := p.Condition("content-length-range"); !found || condition.Name() != "content-length-range" {
+		t.Errorf("Expected a content-length-range condition: %v", p.Conditions)
+	}
+}
+
+func TestDegenerateParsePolicyErrorOffset(t *testing.T) {
+	doc := `{"expiration": "2012-01-01T00:00:00.000Z",
+  "conditions": [
+    {"bucket": "b"},
+    ["starts-with", "$key", 42]
+  ]
+}`
+	_, ok := ParsePolicy([]byte(doc))
+	var parseError *ParseError
+	if !errors.As(ok, &parseError) {
+		t.Fatalf("Expected a *ParseError, got: %#v", ok)
+	}
+	if expected := int64(strings.Index(doc, "42")); parseError.Offset != expected {
+		t.Errorf("Expected the error at offset %d, got %d: %s", expected, parseError.Offset, parseError)
+	}
+	if parseError.Error() != `Invalid policy at $.conditions[1][2] (offset 110): The value of starts-with must be a string, not 42.` {
+		t.Errorf("Unexpected message: %s", parseError)
+	}
+}
+
+func FuzzParsePolicy(f *testing.F) {
+	for _, seed := range []string{
+		aws_example_file_upload_policy,
+		aws_example_text_area_upload,
+		exact_match,
+		startswith_match,
+		range_match,
+		startswith_match_invalid,
+		no_condition_policy,
+		`{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [["starts-with", "", ""], 7, null, ["eq"], {"a": []}]}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, doc []byte) {
+		p, ok := ParsePolicy(doc)
+		if ok != nil {
+			var parseError *ParseError
+			if !errors.As(ok, &parseError) {
+				t.Fatalf("Expected a *ParseError, got: %#v", ok)
+			}
+			if parseError.Offset < 0 || parseError.Offset > int64(len(doc)) {
+				t.Fatalf("Offset %d is outside of the document: %s", parseError.Offset, parseError)
+			}
+			return
+		}
+
+		canonical, ok := p.Canonical()
+		if ok != nil {
+			t.Fatalf("Unable to serialize a parsed policy: %s", ok)
+		}
+		reparsed, ok := ParsePolicy(canonical)
+		if ok != nil {
+			t.Fatalf("Unable to parse the canonical form %s: %s", canonical, ok)
+		}
+		if len(reparsed.Conditions) != len(p.Conditions) {
+			t.Errorf("Conditions changed by a round trip: %v != %v", reparsed.Conditions, p.Conditions)
+		}
+	})
+}
@@ -1,16 +1,30 @@
 package policy
 
 import (
-	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+/*
+FieldName is the form field a condition names.  Conditions may refer to a
+field with or without the leading $ and S3 compares field names without
+regard to case.
+*/
+func FieldName(name string) string {
+	return strings.ToLower(strings.TrimPrefix(name, "$"))
+}
+
+func sameField(a, b string) bool {
+	return FieldName(a) == FieldName(b)
+}
+
 type Condition interface {
 	Matches(key, value string) bool
 	Name() string
@@ -28,17 +42,11 @@ type ConditionEq struct {
 }
 
 func (c ConditionEq) MarshalJSON() (b []byte, ok error) {
-	writer := bytes.NewBuffer(b)
-	_, ok = writer.WriteString(`{"`)
-	_, ok = writer.WriteString(c.Key)
-	_, ok = writer.WriteString(`": "`)
-	_, ok = writer.WriteString(c.Value)
-	_, ok = writer.WriteString(`"}`)
-	return writer.Bytes(), nil
+	return json.Marshal(map[string]string{c.Key: c.Value})
 }
 
 func (c ConditionEq) Matches(key, value string) bool {
-	return c.Key == key && c.Value == value
+	return sameField(c.Key, key) && c.Value == value
 }
 
 func (c ConditionEq) Name() string {
@@ -60,17 +68,15 @@ type ConditionStartsWith struct {
 }
 
 func (c ConditionStartsWith) MarshalJSON() (b []byte, ok error) {
-	writer := bytes.NewBuffer(b)
-	_, ok = writer.WriteString(`["starts-with", "`)
-	_, ok = writer.WriteString(c.Key)
-	_, ok = writer.WriteString(`", "`)
-	_, ok = writer.WriteString(c.Value)
-	_, ok = writer.WriteString(`"]`)
-	return writer.Bytes(), nil
+	return json.Marshal([]string{"starts-with", c.Key, c.Value})
 }
 
+/*
+Matches when value begins with the prefix.  An empty prefix matches any
+value.
+*/
 func (c ConditionStartsWith) Matches(key, value string) bool {
-	return c.Key == key && c.Value == value
+	return sameField(c.Key, key) && strings.HasPrefix(value, c.Value)
 }
 
 func (c ConditionStartsWith) Name() string {
@@ -87,20 +93,31 @@ Support for condition range elements.
 http://docs.aws.amazon.com/AmazonS3/latest/dev/HTTPPOSTForms.html#ConditionMatching
 */
 type ConditionRange struct {
-	key      string
-	min, max float64
+	Key      string
+	Min, Max float64
 }
 
+func (c ConditionRange) MarshalJSON() (b []byte, ok error) {
+	return json.Marshal([]interface{}{c.Key, c.Min, c.Max})
+}
+
+/*
+Matches when value is a number between min and max, inclusive.
+*/
 func (c ConditionRange) Matches(key, value string) bool {
-	return c.key == key
+	if !sameField(c.Key, key) {
+		return false
+	}
+	n, ok := strconv.ParseFloat(value, 64)
+	return ok == nil && c.Min <= n && n <= c.Max
 }
 
 func (c ConditionRange) Name() string {
-	return c.key
+	return c.Key
 }
 
 func (c ConditionRange) ValueString() string {
-	return fmt.Sprint(c.min, c.max)
+	return fmt.Sprint(c.Min, c.Max)
 }
 
 /*
@@ -123,106 +140,127 @@ func (p *Policy) ConditionMatches(key, value string) bool {
 	return false
 }
 
+/*
+Condition returns the first condition on the field key, which may be named
+with or without the leading $.
+*/
 func (p *Policy) Condition(key string) (condition Condition, ok bool) {
 	for _, condition := range p.Conditions {
-		if condition.Name() == key {
+		if sameField(condition.Name(), key) {
 			return condition, true
 		}
 	}
 	return nil, false
 }
 
+/*
+UnmarshalJSON parses a policy document.  Malformed documents are reported as
+a *ParseError.
+*/
 func (p *Policy) UnmarshalJSON(bytes []byte) error {
-
-	var doc struct {
-		Expiration *time.Time    `json:"expiration"`
-		Conditions []interface{} `json:"conditions"`
-	}
-
-	if ok := json.Unmarshal(bytes, &doc); ok != nil {
-		fmt.Printf("Error: %+v", ok)
-		return ok
-	}
-
-	if doc.Expiration == nil {
-		return errors.New("Missing expiration element.")
-	}
-
-	if doc.Conditions == nil {
-		return errors.New("Missing conditions element.")
-	}
-
-	p.Expiration = *doc.Expiration
-
-	for _, condition := range doc.Conditions {
-		switch condition.(type) {
-		case map[string]interface{}:
-			m := condition.(map[string]interface{})
-			for k, v := range m {
-				p.AddConditionEq(k, v.(string))
-			}
-		case []interface{}:
-			l := condition.([]interface{})
-			switch l[0].(string) {
-			case "starts-with":
-				if e := p.AddConditionStartsWith(l[1].(string), l[2].(string)); e != nil {
-					return e
-				}
-			case "eq":
-				p.AddConditionEq(l[1].(string), l[2].(string))
-			default:
-				p.AddConditionRange(l[0].(string), l[1].(float64), l[2].(float64))
-			}
-		default:
-			panic("unreachable")
-		}
-	}
-	return p.checkForRequiredFields()
+	return newPolicyParser(bytes).parse(p)
 }
 
 func (p *Policy) checkForRequiredFields() error {
-	found := []bool{false, false}
-	for i, field := range []string{"$key", "bucket"} {
-		for _, condition := range p.Conditions {
-			if condition.Name() == field {
-				found[i] = true
-				continue
-			}
+	for _, field := range []string{"$key", "bucket"} {
+		if _, found := p.Condition(field); !found {
+			return fmt.Errorf("Missing required field %s.", field)
 		}
 	}
-	if found[0] && found[1] {
-		return nil
-	}
-	return errors.New("Missing required field.")
+	return nil
 }
 
 func NewPolicy(expiration time.Time) (policy *Policy, ok error) {
 	return &Policy{expiration, make([]Condition, 0, 0), nil}, nil
 }
 
+/*
+ParsePolicy parses a policy document, keeping its exact bytes for signing.
+Malformed documents, including any that are not a single JSON object, are
+reported as a *ParseError.
+*/
 func ParsePolicy(bytes []byte) (policy *Policy, ok error) {
-	ok = json.Unmarshal(bytes, &policy)
-	if ok != nil {
-		return
+	parser := newPolicyParser(bytes)
+	policy = &Policy{}
+	if ok = parser.parse(policy); ok != nil {
+		return nil, ok
+	}
+	if ok = parser.end(); ok != nil {
+		return nil, ok
 	}
 	policy.raw = bytes
-	return
+	return policy, nil
 }
 
 func (p *Policy) AddConditionEq(field, value string) {
 	p.Conditions = append(p.Conditions, ConditionEq{field, value})
+	p.raw = nil
+}
+
+/*
+setConditionEq makes field an exact match for value, replacing any existing
+condition on field.  The raw document no longer describes the policy once it
+changes.
+*/
+func (p *Policy) setConditionEq(field, value string) {
+	for i, condition := range p.Conditions {
+		if !sameField(condition.Name(), field) {
+			continue
+		}
+		if eq, isEq := condition.(ConditionEq); isEq && eq.Value == value {
+			return
+		}
+		p.Conditions[i] = ConditionEq{field, value}
+		p.raw = nil
+		return
+	}
+	p.AddConditionEq(field, value)
 }
 
 func (p *Policy) AddConditionStartsWith(field, value string) error {
-	if field[0] != '$' {
+	if !strings.HasPrefix(field, "$") {
 		return errors.New("Invalid key definition.  Key must start with $.")
 	}
 	p.Conditions = append(p.Conditions, ConditionStartsWith{field, value})
+	p.raw = nil
 	return nil
 }
 
 func (p *Policy) AddConditionRange(field string, min, max float64) {
 	p.Conditions = append(p.Conditions, ConditionRange{field, min, max})
+	p.raw = nil
+}
+
+/*
+ExpirationFormat is the ISO 8601 form S3 documents for the expiration.
+*/
+const ExpirationFormat = "2006-01-02T15:04:05.000Z"
+
+/*
+Canonical serializes the policy for signing: the expiration in UTC with
+millisecond precision followed by the conditions in the order they were
+added.  The same policy always produces the same bytes.
+*/
+func (p *Policy) Canonical() (b []byte, ok error) {
+	conditions := p.Conditions
+	if conditions == nil {
+		conditions = []Condition{}
+	}
+	return json.Marshal(struct {
+		Expiration string      `json:"expiration"`
+		Conditions []Condition `json:"conditions"`
+	}{p.Expiration.UTC().Format(ExpirationFormat), conditions})
+}
+
+/*
+document is the exact bytes a signature covers: the document as it was
+parsed or, for a policy that was built or changed since, its canonical form.
+*/
+func (p *Policy) document() (b []byte, ok error) {
+	if p.raw != nil {
+		return p.raw, nil
+	}
+	return p.Canonical()
 }
 
 /*
@@ -239,6 +277,14 @@ func NewS3DropboxSigner(AWSSecretKeyId string, AWSSecretKey string) (signer *Sig
 	return &Signer{nil, AWSSecretKeyId, AWSSecretKey}, nil
 }
 
+/*
+AWSAccessKeyId identifies the credentials the signature is made with.  It is
+sent alongside the signature in the AWSAccessKeyId form field.
+*/
+func (signer *Signer) AWSAccessKeyId() string {
+	return signer.awsSecretKeyId
+}
+
 /*
 Add a policy to be signed.  This will replace any existing policy.
 */
@@ -258,14 +304,24 @@ func (signer *Signer) Sign() (base64enc, sig []byte, ok error) {
 	if signer.policy == nil {
 		return nil, nil, errors.New("Missing policy.  Use AddPolicy(...) to add a policy.")
 	}
-	base64enc = signer.base64encodePolicy()
+	if base64enc, ok = signer.base64encodePolicy(); ok != nil {
+		return nil, nil, ok
+	}
 	sig = signer.hmacPolicy(base64enc)
 	return
 }
 
-func (signer *Signer) base64encodePolicy() (base64enc []byte) {
-	base64enc = make([]byte, base64.StdEncoding.EncodedLen(len(signer.policy.raw)))
-	base64.StdEncoding.Encode(base64enc, signer.policy.raw)
+func (signer *Signer) base64encodePolicy() (base64enc []byte, ok error) {
+	return base64encode(signer.policy)
+}
+
+func base64encode(policy *Policy) (base64enc []byte, ok error) {
+	raw, ok := policy.document()
+	if ok != nil {
+		return nil, ok
+	}
+	base64enc = make([]byte, base64.StdEncoding.EncodedLen(len(raw)))
+	base64.StdEncoding.Encode(base64enc, raw)
 	return
 }
 
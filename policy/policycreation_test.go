@@ -98,3 +98,14 @@ func TestAddConditionStartsWith(t *testing.T) {
 	conditions, _ := convertToRawInterface(t, policy)["conditions"]
 	checkConditionStartWith(t, toArray(conditions, 0), "$key", "/foo/bar")
 }
+
+func TestConditionByFieldName(t *testing.T) {
+	policy, _ := NewPolicy(nowPlusOneYear())
+	policy.AddConditionEq("key", "user/eric/file1.ext")
+	policy.AddConditionEq("$Content-Type", "text/plain")
+	for name, expected := range map[string]string{"$key": "user/eric/file1.ext", "key": "user/eric/file1.ext", "content-type": "text/plain"} {
+		if condition, found := policy.Condition(name); !found || condition.ValueString() != expected {
+			t.Errorf("Condition(%s) should find %s", name, expected)
+		}
+	}
+}
@@ -12,7 +12,7 @@ const (
 	exact_match                       = `{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [ {"$key": "barfoo"}, {"bucket": "bucketfoo"}, {"el": "val"}, ["eq", "el2", "val2"] ] }`
 	startswith_match_invalid          = `{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [ {"starts-with", "key", "barfoo"}, {"bucket": "bucketfoo"}, ["starts-with", "sw", "val"] ] }`
 	startswith_match                  = `{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [ ["starts-with", "$key", "barfoo"], {"bucket": "bucketfoo"}, ["starts-with", "$sw", "val"] ] }`
-	range_match                       = `{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [{"$key": "barfoo"}, {"bucket": "bucketfoo"}, ["foobar", 1, 10]]}`
+	range_match                       = `{"expiration": "2012-01-01T00:00:00.000Z", "conditions": [{"$key": "barfoo"}, {"bucket": "bucketfoo"}, ["content-length-range", 1, 10]]}`
 )
 
 const (
@@ -123,5 +123,5 @@ func TestParsePolicyRangeMatch(t *testing.T) {
 		t.Errorf("Unable to parse range_match policy")
 	}
 
-	checkConditionRangeType(t, policy, "foobar", "1 10")
+	checkConditionRangeType(t, policy, "content-length-range", "1 10")
 }
@@ -101,3 +101,55 @@ func TestSignExternalPolicy(t *testing.T) {
 		checkSignature(t, suite.signer, enc, entries[1], sig, entries[2])
 	}
 }
+
+const canonical_example = `{"expiration":"2007-12-01T12:00:00.000Z","conditions":[{"bucket":"johnsmith"},["starts-with","$key","user/eric/"],{"acl":"public-read"},{"success_action_redirect":"http://johnsmith.s3.amazonaws.com/successful_upload.html"},["starts-with","$Content-Type","image/"],{"x-amz-meta-uuid":"14365123651274"},["starts-with","$x-amz-meta-tag",""],["content-length-range",0,10485760]]}`
+
+func builtExamplePolicy() *Policy {
+	policy, _ := NewPolicy(time.Date(2007, time.December, 1, 7, 0, 0, 0, time.FixedZone("EST", -5*60*60)))
+	policy.AddConditionEq("bucket", "johnsmith")
+	policy.AddConditionStartsWith("$key", "user/eric/")
+	policy.AddConditionEq("acl", "public-read")
+	policy.AddConditionEq("success_action_redirect", "http://johnsmith.s3.amazonaws.com/successful_upload.html")
+	policy.AddConditionStartsWith("$Content-Type", "image/")
+	policy.AddConditionEq("x-amz-meta-uuid", "14365123651274")
+	policy.AddConditionStartsWith("$x-amz-meta-tag", "")
+	policy.AddConditionRange("content-length-range", 0, 10485760)
+	return policy
+}
+
+func TestCanonicalPolicy(t *testing.T) {
+	raw, ok := builtExamplePolicy().Canonical()
+	if ok != nil {
+		t.Fatalf("Unable to serialize policy: %s", ok)
+	}
+	if string(raw) != canonical_example {
+		t.Errorf("Unexpected canonical policy.\nExpected: %s\nActual: %s", canonical_example, raw)
+	}
+}
+
+func TestSignBuiltPolicy(t *testing.T) {
+	signer, _ := NewS3DropboxSigner(AWS_SECRET_KEY_ID, AWS_SECRET_KEY)
+	signer.AddPolicy(builtExamplePolicy())
+	enc, sig, ok := signer.Sign()
+	if ok != nil {
+		t.Fatalf("Unable to sign a built policy: %s", ok)
+	}
+	checkSignature(t, signer, enc, []byte(base64.StdEncoding.EncodeToString([]byte(canonical_example))), sig, []byte("nL6kyVmaixURcqyKzQ4owMVZ+54="))
+
+	signer.AddPolicy(builtExamplePolicy())
+	_, again, _ := signer.Sign()
+	if !bytes.Equal(sig, again) {
+		t.Errorf("Signing the same policy twice should be reproducible")
+	}
+}
+
+func TestSignParsedPolicyAfterChange(t *testing.T) {
+	policy, _ := ParsePolicy([]byte(aws_example_file_upload_policy))
+	policy.AddConditionRange("content-length-range", 0, 10485760)
+	signer, _ := NewS3DropboxSigner(AWS_SECRET_KEY_ID, AWS_SECRET_KEY)
+	signer.AddPolicy(policy)
+	enc, _, _ := signer.Sign()
+	if string(enc) != base64.StdEncoding.EncodeToString([]byte(canonical_example)) {
+		t.Errorf("A changed policy should be signed in its canonical form: %s", enc)
+	}
+}
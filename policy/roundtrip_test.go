@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+/*
+randomPolicy generates policies with every condition form S3 supports and
+values that need escaping.
+*/
+type randomPolicy struct {
+	*Policy
+}
+
+var awkwardStrings = []string{"", `"`, `\`, `"quoted"`, "new\nline", "tab\t", "</script>", "a&b", "ünïcödé", " ", "${filename}", "\x00"}
+
+func randomString(r *rand.Rand) string {
+	if r.Intn(2) == 0 {
+		return awkwardStrings[r.Intn(len(awkwardStrings))]
+	}
+	runes := make([]rune, r.Intn(16))
+	for i := range runes {
+		runes[i] = rune(r.Intn(0x2000))
+	}
+	return string(runes)
+}
+
+func randomFloat(r *rand.Rand) float64 {
+	switch r.Intn(3) {
+	case 0:
+		return float64(r.Int63n(5 << 30))
+	case 1:
+		return r.NormFloat64() * 1e6
+	default:
+		return math.Float64frombits(r.Uint64()&^(0x7ff<<52) | uint64(r.Intn(0x7fe))<<52)
+	}
+}
+
+func (randomPolicy) Generate(r *rand.Rand, size int) reflect.Value {
+	expiration := time.Unix(r.Int63n(1<<33), r.Int63n(int64(time.Second))).UTC()
+	p, _ := NewPolicy(expiration)
+	p.AddConditionEq("bucket", randomString(r))
+	p.AddConditionStartsWith("$key", randomString(r))
+	for i := 0; i < r.Intn(size+1); i++ {
+		switch r.Intn(4) {
+		case 0:
+			p.AddConditionEq(randomString(r), randomString(r))
+		case 1:
+			p.AddConditionEq("$"+randomString(r), randomString(r))
+		case 2:
+			p.AddConditionStartsWith("$"+randomString(r), randomString(r))
+		default:
+			p.AddConditionRange("content-length-range", randomFloat(r), randomFloat(r))
+		}
+	}
+	return reflect.ValueOf(randomPolicy{p})
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	roundTrip := func(random randomPolicy) bool {
+		raw, ok := json.Marshal(random.Policy)
+		if ok != nil {
+			t.Logf("Unable to marshal: %s", ok)
+			return false
+		}
+		parsed, ok := ParsePolicy(raw)
+		if ok != nil {
+			t.Logf("Unable to parse %s: %s", raw, ok)
+			return false
+		}
+		parsed.raw = nil
+		if !reflect.DeepEqual(parsed, random.Policy) {
+			t.Logf("Round trip lost information.\nExpected: %#v\nActual: %#v", random.Policy, parsed)
+			return false
+		}
+		return true
+	}
+	if ok := quick.Check(roundTrip, &quick.Config{MaxCount: 500}); ok != nil {
+		t.Error(ok)
+	}
+}
+
+func TestMarshalRoundTripAWSExamples(t *testing.T) {
+	for _, example := range []string{aws_example_file_upload_policy, aws_example_text_area_upload, aws_sigv4_example_policy, range_match} {
+		p, _ := ParsePolicy([]byte(example))
+		raw, _ := json.Marshal(p)
+		parsed, ok := ParsePolicy(raw)
+		if ok != nil {
+			t.Fatalf("Unable to parse marshaled policy %s: %s", raw, ok)
+		}
+		p.raw, parsed.raw = nil, nil
+		if !reflect.DeepEqual(p, parsed) {
+			t.Errorf("Round trip lost information.\nExpected: %#v\nActual: %#v", p, parsed)
+		}
+	}
+}
+
+func TestMarshalRangeCondition(t *testing.T) {
+	raw, _ := json.Marshal(ConditionRange{"content-length-range", 1048576, 10485760})
+	if string(raw) != `["content-length-range",1048576,10485760]` {
+		t.Errorf("Unexpected range JSON: %s", raw)
+	}
+}
+
+func TestMarshalEscapesValues(t *testing.T) {
+	raw, _ := json.Marshal(ConditionEq{"x-amz-meta-title", `"quoted"`})
+	var decoded map[string]string
+	if ok := json.Unmarshal(raw, &decoded); ok != nil || decoded["x-amz-meta-title"] != `"quoted"` {
+		t.Errorf("Quotes should be escaped: %s", raw)
+	}
+}
@@ -0,0 +1,134 @@
+package policy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	SigV4Algorithm = "AWS4-HMAC-SHA256"
+	sigV4Scope     = "aws4_request"
+	sigV4DateShort = "20060102"
+	sigV4DateLong  = "20060102T150405Z"
+)
+
+/*
+SignerV4 signs a policy with AWS Signature Version 4.  Unlike Signer, the
+policy itself carries the credential scope, so Sign adds the x-amz-algorithm,
+x-amz-credential and x-amz-date conditions (and x-amz-security-token for
+temporary credentials) before signing.  The signature is sent in the
+x-amz-signature form field.
+
+http://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-authentication-HTTPPOST.html
+*/
+type SignerV4 struct {
+	policy         *Policy
+	awsAccessKeyId string
+	awsSecretKey   string
+	sessionToken   string
+	region         string
+	service        string
+	date           time.Time
+}
+
+/*
+NewSigV4Signer creates a signer for region whose signatures are scoped to
+date.
+*/
+func NewSigV4Signer(AWSAccessKeyId, AWSSecretKey, region string, date time.Time) (signer *SignerV4, ok error) {
+	if region == "" {
+		return nil, errors.New("Missing region.  Signature Version 4 is scoped to a region.")
+	}
+	return &SignerV4{
+		awsAccessKeyId: AWSAccessKeyId,
+		awsSecretKey:   AWSSecretKey,
+		region:         region,
+		service:        "s3",
+		date:           date.UTC(),
+	}, nil
+}
+
+/*
+SetSessionToken signs with temporary credentials.  The token is added to the
+policy as an x-amz-security-token condition.
+*/
+func (signer *SignerV4) SetSessionToken(token string) {
+	signer.sessionToken = token
+}
+
+/*
+Add a policy to be signed.  This will replace any existing policy.
+*/
+func (signer *SignerV4) AddPolicy(policy *Policy) (ok error) {
+	if policy == nil {
+		return errors.New("Missing policy")
+	}
+	signer.policy = policy
+	return nil
+}
+
+/*
+Credential is the value of the x-amz-credential field:
+<access key id>/<date>/<region>/<service>/aws4_request.
+*/
+func (signer *SignerV4) Credential() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", signer.awsAccessKeyId, signer.date.Format(sigV4DateShort),
+		signer.region, signer.service, sigV4Scope)
+}
+
+/*
+Date is the value of the x-amz-date field.
+*/
+func (signer *SignerV4) Date() string {
+	return signer.date.Format(sigV4DateLong)
+}
+
+/*
+Sign the policy and generate the base64 encoded policy and the hex encoded
+signature.  A parsed policy that already holds the signing conditions is
+signed exactly as it was read.
+*/
+func (signer *SignerV4) Sign() (base64enc, sig []byte, ok error) {
+	if signer.policy == nil {
+		return nil, nil, errors.New("Missing policy.  Use AddPolicy(...) to add a policy.")
+	}
+	signer.policy.setConditionEq("x-amz-algorithm", SigV4Algorithm)
+	signer.policy.setConditionEq("x-amz-credential", signer.Credential())
+	signer.policy.setConditionEq("x-amz-date", signer.Date())
+	if signer.sessionToken != "" {
+		signer.policy.setConditionEq("x-amz-security-token", signer.sessionToken)
+	}
+
+	if base64enc, ok = base64encode(signer.policy); ok != nil {
+		return nil, nil, ok
+	}
+
+	key := DeriveSigningKey(signer.awsSecretKey, signer.date, signer.region, signer.service)
+	rawsig := hmacSHA256(key, base64enc)
+	sig = make([]byte, hex.EncodedLen(len(rawsig)))
+	hex.Encode(sig, rawsig)
+	return
+}
+
+/*
+DeriveSigningKey computes the Signature Version 4 signing key for the secret
+scoped to date, region and service.
+
+http://docs.aws.amazon.com/general/latest/gr/signature-v4-examples.html
+*/
+func DeriveSigningKey(secret string, date time.Time, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date.UTC().Format(sigV4DateShort)))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte(sigV4Scope))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	hasher := hmac.New(sha256.New, key)
+	hasher.Write(data)
+	return hasher.Sum(nil)
+}
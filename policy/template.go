@@ -0,0 +1,334 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/internal/yaml"
+)
+
+/*
+DefaultExpiresIn is how long a policy made from a template is valid when
+neither the template nor the caller choose.
+*/
+const DefaultExpiresIn = time.Hour
+
+/*
+Template describes a family of policies.  Its conditions are written as in a
+policy document, but strings may refer to variables as ${name} and ranges may
+use sizes such as 10MB.  ${filename} is left for S3 to replace.  Templates
+are JSON or YAML:
+
+	expires_in: 15m
+	variables:
+	  prefix: uploads/
+	conditions:
+	  - bucket: ${bucket}
+	  - [starts-with, $key, "${prefix}${user}/"]
+	  - [content-length-range, 1, 10MB]
+*/
+type Template struct {
+	ExpiresIn  time.Duration
+	Variables  map[string]string
+	Conditions []interface{}
+}
+
+var presets = map[string]string{
+	"user-upload-prefix": `
+variables:
+  prefix: uploads/
+conditions:
+  - bucket: ${bucket}
+  - [starts-with, $key, "${prefix}${user}/"]
+`,
+	"images-only": `
+conditions:
+  - [starts-with, $Content-Type, image/]
+`,
+	"max-10MB": `
+conditions:
+  - [content-length-range, 0, 10MB]
+`,
+}
+
+/*
+PresetNames lists the templates built into s3dropbox.
+*/
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+Preset returns the built in template called name.
+*/
+func Preset(name string) (*Template, error) {
+	doc, found := presets[name]
+	if !found {
+		return nil, fmt.Errorf("Unknown preset %q.  Presets are %s.", name, strings.Join(PresetNames(), ", "))
+	}
+	return ParseTemplate([]byte(doc))
+}
+
+/*
+ParseTemplate reads a JSON or YAML template.
+*/
+func ParseTemplate(doc []byte) (t *Template, ok error) {
+	var value interface{}
+	if trimmed := bytes.TrimSpace(doc); len(trimmed) > 0 && trimmed[0] == '{' {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.UseNumber()
+		if ok = decoder.Decode(&value); ok != nil {
+			return nil, fmt.Errorf("Invalid JSON template: %s", ok)
+		}
+	} else if value, ok = yaml.Parse(doc); ok != nil {
+		return nil, fmt.Errorf("Invalid YAML template: %s", ok)
+	}
+
+	fields, isMap := value.(map[string]interface{})
+	if !isMap {
+		return nil, fmt.Errorf("A template must be a mapping of expires_in, variables and conditions.")
+	}
+	t = &Template{Variables: map[string]string{}}
+	for name, value := range fields {
+		switch name {
+		case "expires_in":
+			text, isString := value.(string)
+			if !isString {
+				return nil, fmt.Errorf("expires_in must be a duration such as 15m.")
+			}
+			if t.ExpiresIn, ok = ParseExpiresIn(text); ok != nil {
+				return nil, ok
+			}
+		case "variables":
+			variables, isMap := value.(map[string]interface{})
+			if !isMap {
+				return nil, fmt.Errorf("variables must be a mapping of names to values.")
+			}
+			for name, value := range variables {
+				text, isString := value.(string)
+				if !isString {
+					return nil, fmt.Errorf("The value of variable %s must be a string.", name)
+				}
+				t.Variables[name] = text
+			}
+		case "conditions":
+			conditions, isList := value.([]interface{})
+			if !isList {
+				return nil, fmt.Errorf("conditions must be a list.")
+			}
+			t.Conditions = conditions
+		default:
+			return nil, fmt.Errorf("Unknown template field %q.  Expected expires_in, variables or conditions.", name)
+		}
+	}
+	return t, nil
+}
+
+/*
+ParseExpiresIn parses a duration such as 15m or 2h30m, and also accepts whole
+days such as 7d.
+*/
+func ParseExpiresIn(text string) (time.Duration, error) {
+	if days, isDays := strings.CutSuffix(text, "d"); isDays {
+		if n, ok := strconv.Atoi(days); ok == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	duration, ok := time.ParseDuration(text)
+	if ok != nil || duration <= 0 {
+		return 0, fmt.Errorf("Invalid duration %q.  Expected a positive duration such as 15m, 2h or 7d.", text)
+	}
+	return duration, nil
+}
+
+/*
+CombineTemplates joins templates into one with all of their conditions.  The
+shortest expires_in wins and later templates override the variable defaults
+of earlier ones.
+*/
+func CombineTemplates(templates ...*Template) *Template {
+	combined := &Template{Variables: map[string]string{}}
+	for _, t := range templates {
+		if t.ExpiresIn > 0 && (combined.ExpiresIn == 0 || t.ExpiresIn < combined.ExpiresIn) {
+			combined.ExpiresIn = t.ExpiresIn
+		}
+		for name, value := range t.Variables {
+			combined.Variables[name] = value
+		}
+		combined.Conditions = append(combined.Conditions, t.Conditions...)
+	}
+	return combined
+}
+
+/*
+Expiration is now plus the template's expires_in, or DefaultExpiresIn.
+*/
+func (t *Template) Expiration(now time.Time) time.Time {
+	if t.ExpiresIn > 0 {
+		return now.Add(t.ExpiresIn)
+	}
+	return now.Add(DefaultExpiresIn)
+}
+
+/*
+Policy builds the policy described by the template, expiring at expiration.
+variables override the template's defaults; every variable a condition
+refers to must have a value.
+*/
+func (t *Template) Policy(expiration time.Time, variables map[string]string) (p *Policy, ok error) {
+	values := map[string]string{}
+	for name, value := range t.Variables {
+		values[name] = value
+	}
+	for name, value := range variables {
+		values[name] = value
+	}
+
+	if p, ok = NewPolicy(expiration); ok != nil {
+		return nil, ok
+	}
+	for i, condition := range t.Conditions {
+		if ok = addTemplateCondition(p, condition, values); ok != nil {
+			return nil, fmt.Errorf("conditions[%d]: %s", i, ok)
+		}
+	}
+	return p, nil
+}
+
+func addTemplateCondition(p *Policy, condition interface{}, values map[string]string) error {
+	switch condition := condition.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(condition))
+		for name := range condition {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value, ok := substitute(condition[name], values)
+			if ok != nil {
+				return fmt.Errorf("%s: %s", name, ok)
+			}
+			p.AddConditionEq(name, value)
+		}
+		return nil
+	case []interface{}:
+		if len(condition) != 3 {
+			return fmt.Errorf("A condition list must have 3 elements, not %d.", len(condition))
+		}
+		operator, isString := condition[0].(string)
+		if !isString {
+			return fmt.Errorf("The condition operator must be a string.")
+		}
+		operator, ok := conditionOperator(operator)
+		if ok != nil {
+			return ok
+		}
+		if operator == "eq" || operator == "starts-with" {
+			field, ok := substitute(condition[1], values)
+			if ok != nil {
+				return ok
+			}
+			value, ok := substitute(condition[2], values)
+			if ok != nil {
+				return ok
+			}
+			if operator == "eq" {
+				p.AddConditionEq(field, value)
+				return nil
+			}
+			return p.AddConditionStartsWith(field, value)
+		}
+		min, ok := templateSize(condition[1])
+		if ok != nil {
+			return ok
+		}
+		max, ok := templateSize(condition[2])
+		if ok != nil {
+			return ok
+		}
+		if min > max {
+			return fmt.Errorf("The minimum of %s is greater than the maximum.", operator)
+		}
+		p.AddConditionRange(operator, min, max)
+		return nil
+	default:
+		return fmt.Errorf("A condition must be a mapping or a list.")
+	}
+}
+
+var variablePattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+/*
+substitute replaces the variables in a template string.
+*/
+func substitute(value interface{}, values map[string]string) (string, error) {
+	text, isString := value.(string)
+	if !isString {
+		return "", fmt.Errorf("Expected a string, not %s.", describeJSON(value))
+	}
+	var missing []string
+	text = variablePattern.ReplaceAllStringFunc(text, func(reference string) string {
+		name := reference[2 : len(reference)-1]
+		if name == "filename" {
+			return reference
+		}
+		value, found := values[name]
+		if !found {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if missing != nil {
+		return "", fmt.Errorf("Missing variable %s.", strings.Join(missing, ", "))
+	}
+	return text, nil
+}
+
+/*
+ParseSize parses a number of bytes or a size such as 10MB, in binary units
+as in templates.
+*/
+func ParseSize(text string) (int64, error) {
+	size, ok := templateSize(text)
+	if ok != nil {
+		return 0, ok
+	}
+	return int64(size), nil
+}
+
+var sizeUnits = map[string]float64{"": 1, "B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30, "TB": 1 << 40}
+
+/*
+templateSize reads a range bound: a number or a size such as 10MB.  Sizes
+are in binary units, so 1KB is 1024 bytes.
+*/
+func templateSize(value interface{}) (float64, error) {
+	var text string
+	switch value := value.(type) {
+	case json.Number:
+		text = value.String()
+	case string:
+		text = strings.TrimSpace(value)
+	default:
+		return 0, fmt.Errorf("Expected a number or size, not %s.", describeJSON(value))
+	}
+
+	number := strings.TrimRightFunc(text, func(r rune) bool { return r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' })
+	unit, found := sizeUnits[strings.ToUpper(strings.TrimSpace(text[len(number):]))]
+	n, ok := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if !found || ok != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size %q.  Expected a number of bytes or a size such as 10MB.", text)
+	}
+	return n * unit, nil
+}
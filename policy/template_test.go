@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	yaml_template = `# Uploads into a user's own directory.
+expires_in: 15m
+variables:
+  prefix: "uploads/"
+conditions:
+  - bucket: ${bucket}
+    acl: private
+  - [starts-with, $key, "${prefix}${user}/${filename}"]
+  - ["eq", "$success_action_redirect", 'http://example.com/done?who=${user}']
+  -
+    - content-length-range
+    - 1
+    - 10MB
+  - {x-amz-meta-note: "a, b # c"}
+`
+	json_template = `{
+  "expires_in": "15m",
+  "variables": {"prefix": "uploads/"},
+  "conditions": [
+    {"acl": "private", "bucket": "${bucket}"},
+    ["starts-with", "$key", "${prefix}${user}/${filename}"],
+    ["eq", "$success_action_redirect", "http://example.com/done?who=${user}"],
+    ["content-length-range", 1, 10485760],
+    {"x-amz-meta-note": "a, b # c"}
+  ]
+}`
+)
+
+var template_variables = map[string]string{"bucket": "johnsmith", "user": "eric"}
+
+func TestTemplateYAMLAndJSON(t *testing.T) {
+	expiration := time.Date(2007, 12, 1, 12, 0, 0, 0, time.UTC)
+	var documents []string
+	for _, doc := range []string{yaml_template, json_template} {
+		template, ok := ParseTemplate([]byte(doc))
+		if ok != nil {
+			t.Fatalf("Unable to parse the template: %s", ok)
+		}
+		if template.ExpiresIn != 15*time.Minute {
+			t.Errorf("Unexpected expires_in: %s", template.ExpiresIn)
+		}
+		p, ok := template.Policy(expiration, template_variables)
+		if ok != nil {
+			t.Fatalf("Unable to build the policy: %s", ok)
+		}
+		canonical, _ := p.Canonical()
+		documents = append(documents, string(canonical))
+	}
+
+	expected := `{"expiration":"2007-12-01T12:00:00.000Z","conditions":[{"acl":"private"},{"bucket":"johnsmith"},` +
+		`["starts-with","$key","uploads/eric/${filename}"],{"$success_action_redirect":"http://example.com/done?who=eric"},` +
+		`["content-length-range",1,10485760],{"x-amz-meta-note":"a, b # c"}]}`
+	for _, doc := range documents {
+		if doc != expected {
+			t.Errorf("Unexpected policy.\nExpected: %s\nActual:   %s", expected, doc)
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+	var templates []*Template
+	for _, name := range []string{"user-upload-prefix", "images-only", "max-10MB"} {
+		template, ok := Preset(name)
+		if ok != nil {
+			t.Fatalf("Unable to load preset %s: %s", name, ok)
+		}
+		templates = append(templates, template)
+	}
+	if names := PresetNames(); !reflect.DeepEqual(names, []string{"images-only", "max-10MB", "user-upload-prefix"}) {
+		t.Errorf("Unexpected presets: %v", names)
+	}
+
+	now := time.Date(2007, 12, 1, 11, 0, 0, 0, time.UTC)
+	template := CombineTemplates(templates...)
+	p, ok := template.Policy(template.Expiration(now), template_variables)
+	if ok != nil {
+		t.Fatalf("Unable to build the policy: %s", ok)
+	}
+	canonical, _ := p.Canonical()
+	if diagnostics := Lint(canonical, now); len(diagnostics) != 0 {
+		t.Errorf("The presets should lint cleanly: %v", diagnostics)
+	}
+	if !p.ConditionMatches("$key", "uploads/eric/photo.jpg") || !p.ConditionMatches("$Content-Type", "image/png") {
+		t.Errorf("Unexpected policy: %s", canonical)
+	}
+	if c, _ := p.Condition("content-length-range"); c.ValueString() != "0 1.048576e+07" {
+		t.Errorf("Unexpected range: %s", c.ValueString())
+	}
+	if !p.Expiration.Equal(now.Add(DefaultExpiresIn)) {
+		t.Errorf("Unexpected expiration: %s", p.Expiration)
+	}
+}
+
+func TestParseExpiresIn(t *testing.T) {
+	for text, expected := range map[string]time.Duration{"15m": 15 * time.Minute, "2h30m": 150 * time.Minute, "7d": 7 * 24 * time.Hour} {
+		if duration, ok := ParseExpiresIn(text); ok != nil || duration != expected {
+			t.Errorf("ParseExpiresIn(%s) = %s, %v", text, duration, ok)
+		}
+	}
+	for _, text := range []string{"", "soon", "-5m", "0s", "1.5d"} {
+		if _, ok := ParseExpiresIn(text); ok == nil {
+			t.Errorf("ParseExpiresIn(%q) should fail", text)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for text, expected := range map[string]int64{"1024": 1024, "10MB": 10 << 20, "1.5 KB": 1536} {
+		if size, ok := ParseSize(text); ok != nil || size != expected {
+			t.Errorf("ParseSize(%s) = %d, %v", text, size, ok)
+		}
+	}
+	for _, text := range []string{"", "big", "-1", "10XB"} {
+		if _, ok := ParseSize(text); ok == nil {
+			t.Errorf("ParseSize(%q) should fail", text)
+		}
+	}
+}
+
+func TestDegenerateTemplate(t *testing.T) {
+	for doc, message := range map[string]string{
+		``:                       "empty",
+		`- a`:                    "must be a mapping",
+		`expires: 15m`:           `Unknown template field "expires"`,
+		`expires_in: soon`:       `"soon"`,
+		"conditions:\n\t- a":     "spaces, not tabs",
+		"conditions:\n  - [a, b": "Missing",
+		"conditions: a\n  b: c":  "Line 2",
+		"conditions:\n  - {a: b}\n  - {a: b}\nconditions: x": "Duplicate key",
+		`{"conditions": [}`: "Invalid JSON",
+	} {
+		if _, ok := ParseTemplate([]byte(doc)); ok == nil || !strings.Contains(ok.Error(), message) {
+			t.Errorf("Expected an error containing %q for %q, got: %v", message, doc, ok)
+		}
+	}
+}
+
+func TestDegenerateTemplatePolicy(t *testing.T) {
+	expiration := time.Date(2007, 12, 1, 12, 0, 0, 0, time.UTC)
+	for doc, message := range map[string]string{
+		"conditions:\n  - bucket: ${bucket}":               "Missing variable bucket.",
+		"conditions:\n  - [starts-with, key, a]":           "must start with $",
+		"conditions:\n  - [content-length-range, 10, 1]":   "greater than the maximum",
+		"conditions:\n  - [content-length-range, 1, lots]": `"lots"`,
+		"conditions:\n  - [content-length-range, 1]":       "3 elements",
+		"conditions:\n  - [x-amz-meta-size, 1, 10]":        "Unknown condition operator",
+		"conditions:\n  - plain":                           "mapping or a list",
+		"conditions:\n  - bucket: [a]":                     "Expected a string",
+	} {
+		template, ok := ParseTemplate([]byte(doc))
+		if ok != nil {
+			t.Fatalf("Unable to parse %q: %s", doc, ok)
+		}
+		if _, ok = template.Policy(expiration, nil); ok == nil || !strings.Contains(ok.Error(), message) {
+			t.Errorf("Expected an error containing %q for %q, got: %v", message, doc, ok)
+		}
+	}
+}
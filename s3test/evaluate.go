@@ -0,0 +1,187 @@
+package s3test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+condition is one entry of a policy's conditions, read from the JSON as S3
+reads it rather than through the policy package, so a bug there is not
+hidden by the same bug here.  field is lower case without its $.
+*/
+type condition struct {
+	operator string
+	field    string
+	value    string
+	min, max int64
+}
+
+func (c condition) String() string {
+	failed, _ := json.Marshal([]string{c.operator, "$" + c.field, c.value})
+	return string(failed)
+}
+
+/*
+unconditionedFields may be sent without appearing in the policy.
+
+http://docs.aws.amazon.com/AmazonS3/latest/dev/HTTPPOSTForms.html#HTTPPOSTConstructPolicy
+*/
+var unconditionedFields = map[string]bool{
+	"awsaccesskeyid":  true,
+	"signature":       true,
+	"x-amz-signature": true,
+	"file":            true,
+	"policy":          true,
+}
+
+func invalidPolicy(format string, args ...interface{}) *s3Error {
+	return newError(http.StatusBadRequest, "InvalidPolicyDocument", "Invalid Policy: "+format, args...)
+}
+
+/*
+parsePolicy reads the expiration and conditions of a policy document.
+*/
+func parsePolicy(doc []byte) (expiration time.Time, conditions []condition, ok error) {
+	var document struct {
+		Expiration *string           `json:"expiration"`
+		Conditions []json.RawMessage `json:"conditions"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if ok = decoder.Decode(&document); ok != nil {
+		return expiration, nil, invalidPolicy("Invalid JSON.")
+	}
+	if document.Expiration == nil {
+		return expiration, nil, invalidPolicy("Policy missing expiration.")
+	}
+	if expiration, ok = time.Parse(time.RFC3339, *document.Expiration); ok != nil {
+		return expiration, nil, invalidPolicy("Invalid 'expiration' value: '%s'", *document.Expiration)
+	}
+	if document.Conditions == nil {
+		return expiration, nil, invalidPolicy("Policy missing conditions.")
+	}
+	for _, raw := range document.Conditions {
+		parsed, ok := parseCondition(raw)
+		if ok != nil {
+			return expiration, nil, ok
+		}
+		conditions = append(conditions, parsed)
+	}
+	return expiration, conditions, nil
+}
+
+func parseCondition(raw json.RawMessage) (c condition, ok error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if ok = decoder.Decode(&value); ok != nil {
+		return c, invalidPolicy("Invalid JSON.")
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if len(value) != 1 {
+			return c, invalidPolicy("Invalid Simple-Condition: Simple-Conditions must have exactly one property specified.")
+		}
+		for name, value := range value {
+			text, isString := value.(string)
+			if !isString {
+				return c, invalidPolicy("Invalid Simple-Condition: value must be a string.")
+			}
+			c = condition{operator: "eq", field: strings.ToLower(strings.TrimPrefix(name, "$")), value: text}
+		}
+		return c, nil
+	case []interface{}:
+		if len(value) != 3 {
+			return c, invalidPolicy("Invalid Condition: wrong number of arguments.")
+		}
+		operator, isString := value[0].(string)
+		if !isString {
+			return c, invalidPolicy("Invalid Condition: the operator must be a string.")
+		}
+		c.operator = strings.ToLower(operator)
+		switch c.operator {
+		case "eq", "starts-with":
+			field, isField := value[1].(string)
+			text, isText := value[2].(string)
+			if !isField || !strings.HasPrefix(field, "$") || !isText {
+				return c, invalidPolicy("Invalid %s Condition: expected a $field and a string value.", c.operator)
+			}
+			c.field, c.value = strings.ToLower(field[1:]), text
+		case "content-length-range":
+			min, minOk := value[1].(json.Number)
+			max, maxOk := value[2].(json.Number)
+			if !minOk || !maxOk {
+				return c, invalidPolicy("Invalid content-length-range Condition: the bounds must be integers.")
+			}
+			var minErr, maxErr error
+			c.min, minErr = min.Int64()
+			c.max, maxErr = max.Int64()
+			if minErr != nil || maxErr != nil || c.min < 0 {
+				return c, invalidPolicy("Invalid content-length-range Condition: the bounds must be integers.")
+			}
+			if c.min > c.max {
+				return c, invalidPolicy("Invalid content-length-range Condition: the minimum is greater than the maximum.")
+			}
+			c.field = c.operator
+		default:
+			return c, invalidPolicy("Invalid Condition: unknown operator %q.", operator)
+		}
+		return c, nil
+	default:
+		return c, invalidPolicy("Invalid Condition: a condition must be an object or a list.")
+	}
+}
+
+/*
+evaluate reports the first policy violation with the error S3 gives for it.
+The bucket is taken from the request, not the form.
+*/
+func evaluate(conditions []condition, bucket string, upload *form) error {
+	values := map[string]string{}
+	for name, value := range upload.fields {
+		values[name] = value
+	}
+	values["bucket"] = bucket
+	size := int64(len(upload.file))
+
+	covered := map[string]bool{}
+	for _, c := range conditions {
+		covered[c.field] = true
+		value, found := values[c.field]
+		switch c.operator {
+		case "content-length-range":
+			if size < c.min {
+				return newError(http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed size")
+			}
+			if size > c.max {
+				return newError(http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed size")
+			}
+			continue
+		case "eq":
+			found = found && value == c.value
+		case "starts-with":
+			found = found && strings.HasPrefix(value, c.value)
+		}
+		if !found {
+			return newError(http.StatusForbidden, "AccessDenied", "Invalid according to Policy: Policy Condition failed: %s", c)
+		}
+	}
+
+	var extra []string
+	for name := range upload.fields {
+		if !covered[name] && !unconditionedFields[name] && !strings.HasPrefix(name, "x-ignore-") {
+			extra = append(extra, name)
+		}
+	}
+	if extra != nil {
+		sort.Strings(extra)
+		return newError(http.StatusForbidden, "AccessDenied", "Invalid according to Policy: Extra input fields: %s", strings.Join(extra, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package s3test
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+const maxFieldSize = 64 * 1024
+
+// Host names of virtual hosted-style requests: bucket.s3[-accelerate][.dualstack][.region].amazonaws.com[.cn]
+var virtualHost = regexp.MustCompile(`^(.+?)\.s3(-accelerate)?(\.dualstack)?(\.[a-z0-9-]+)?\.amazonaws\.com(\.cn)?$`)
+
+/*
+s3Error is the XML document S3 answers failed requests with.
+
+http://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html
+*/
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	status    int
+	Code      string
+	Message   string
+	RequestId string
+	HostId    string
+}
+
+func newError(status int, code, format string, args ...interface{}) *s3Error {
+	return &s3Error{status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+/*
+postResponse is the body of a 201 response.
+*/
+type postResponse struct {
+	XMLName  xml.Name `xml:"PostResponse"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+/*
+form is the part of a POST Object request S3 considers: the fields before
+the file, and the file.  Anything after the file is ignored.
+*/
+type form struct {
+	fields   map[string]string
+	filename string
+	file     []byte
+	hasFile  bool
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	requestId := fmt.Sprintf("%016X", s.requests)
+	s.mu.Unlock()
+	w.Header().Set("x-amz-request-id", requestId)
+	w.Header().Set("x-amz-id-2", base64.StdEncoding.EncodeToString([]byte("s3test/"+requestId)))
+
+	if ok := s.post(w, r); ok != nil {
+		var s3err *s3Error
+		if !errors.As(ok, &s3err) {
+			s3err = newError(http.StatusInternalServerError, "InternalError", "%s", ok)
+		}
+		s3err.RequestId = requestId
+		s3err.HostId = w.Header().Get("x-amz-id-2")
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(s3err.status)
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(s3err)
+	}
+}
+
+/*
+bucketFrom takes the bucket from the host name of a virtual hosted-style
+request or the path of a path-style request, and returns the URL of the
+bucket.
+*/
+func (s *Server) bucketFrom(r *http.Request) (bucket, bucketURL string) {
+	host := r.Host
+	if h, _, ok := net.SplitHostPort(host); ok == nil {
+		host = h
+	}
+	if match := virtualHost.FindStringSubmatch(host); match != nil {
+		return match[1], "https://" + r.Host + "/"
+	}
+	bucket, _, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return bucket, s.URL + "/" + bucket + "/"
+}
+
+var (
+	bucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	ipAddress  = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+$`)
+)
+
+/*
+validBucket applies S3's bucket naming rules, which also keep a bucket from
+naming a directory outside the one given to WithDir.
+
+https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
+*/
+func validBucket(name string) bool {
+	return bucketName.MatchString(name) && !strings.Contains(name, "..") && !ipAddress.MatchString(name) &&
+		!strings.HasPrefix(name, "xn--") && !strings.HasSuffix(name, "-s3alias")
+}
+
+func (s *Server) post(w http.ResponseWriter, r *http.Request) (ok error) {
+	bucket, bucketURL := s.bucketFrom(r)
+	if r.Method != "POST" {
+		return newError(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	}
+	if bucket != "" && !validBucket(bucket) {
+		return newError(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	}
+	if bucket == "" || len(s.buckets) > 0 && !s.buckets[bucket] {
+		return newError(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	}
+	upload, ok := readForm(r)
+	if ok != nil {
+		return ok
+	}
+
+	encoded, found := upload.fields["policy"]
+	if !found {
+		return newError(http.StatusForbidden, "AccessDenied", "Access Denied")
+	}
+	doc, ok := base64.StdEncoding.DecodeString(encoded)
+	if ok != nil {
+		return newError(http.StatusBadRequest, "InvalidPolicyDocument", "Invalid Policy: Invalid Base64 Encoding.")
+	}
+	expiration, conditions, ok := parsePolicy(doc)
+	if ok != nil {
+		return ok
+	}
+	if ok = s.verifySignature(upload.fields, encoded); ok != nil {
+		return ok
+	}
+	if !expiration.After(s.now()) {
+		return newError(http.StatusForbidden, "AccessDenied", "Invalid according to Policy: Policy expired.")
+	}
+	if ok = evaluate(conditions, bucket, upload); ok != nil {
+		return ok
+	}
+
+	key, found := upload.fields["key"]
+	if !found || key == "" {
+		return newError(http.StatusBadRequest, "InvalidArgument", "Bucket POST must contain a field named 'key'.  If it is specified, please check the order of the fields.")
+	}
+	key = strings.ReplaceAll(key, "${filename}", upload.filename)
+	if ok = verifyDigests(upload); ok != nil {
+		return ok
+	}
+	sum := md5.Sum(upload.file)
+	object := &Object{
+		Bucket: bucket,
+		Key:    key,
+		ETag:   hex.EncodeToString(sum[:]),
+		Size:   int64(len(upload.file)),
+		Fields: upload.fields,
+		body:   upload.file,
+	}
+	if ok = s.store(object); ok != nil {
+		return ok
+	}
+	respond(w, upload.fields, object, bucketURL+(&url.URL{Path: key}).EscapedPath())
+	return nil
+}
+
+func readForm(r *http.Request) (upload *form, ok error) {
+	mediaType, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return nil, newError(http.StatusPreconditionFailed, "PreconditionFailed", "Bucket POST must be of the enclosure-type multipart/form-data")
+	}
+	malformed := newError(http.StatusBadRequest, "MalformedPOSTRequest", "The body of your POST request is not well-formed multipart/form-data.")
+
+	upload = &form{fields: map[string]string{}}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, ok := reader.NextPart()
+		if ok == io.EOF {
+			break
+		}
+		if ok != nil {
+			return nil, malformed
+		}
+		name := strings.ToLower(part.FormName())
+		if name == "file" {
+			upload.filename = part.FileName()
+			if upload.file, ok = io.ReadAll(part); ok != nil {
+				return nil, malformed
+			}
+			upload.hasFile = true
+			break
+		}
+		value, ok := io.ReadAll(io.LimitReader(part, maxFieldSize))
+		if ok != nil {
+			return nil, malformed
+		}
+		upload.fields[name] = string(value)
+	}
+	if !upload.hasFile {
+		return nil, newError(http.StatusBadRequest, "InvalidArgument", "POST requires exactly one file upload per request.")
+	}
+	return upload, nil
+}
+
+/*
+verifySignature checks the Signature Version 4 signature when the form has
+an x-amz-algorithm field, Signature Version 2 otherwise.
+*/
+func (s *Server) verifySignature(fields map[string]string, encoded string) error {
+	mismatch := newError(http.StatusForbidden, "SignatureDoesNotMatch",
+		"The request signature we calculated does not match the signature you provided. Check your key and signing method.")
+
+	var value credentials.Value
+	if algorithm, v4 := fields["x-amz-algorithm"]; v4 {
+		if algorithm != policy.SigV4Algorithm {
+			return newError(http.StatusBadRequest, "InvalidArgument", "Unsupported x-amz-algorithm %s.", algorithm)
+		}
+		scope := strings.Split(fields["x-amz-credential"], "/")
+		if len(scope) != 5 || scope[4] != "aws4_request" {
+			return newError(http.StatusBadRequest, "InvalidArgument", "Invalid x-amz-credential %q.", fields["x-amz-credential"])
+		}
+		date, ok := time.Parse("20060102", scope[1])
+		if ok != nil {
+			return newError(http.StatusBadRequest, "InvalidArgument", "Invalid date in x-amz-credential %q.", scope[1])
+		}
+		var known bool
+		if value, known = s.credentials[scope[0]]; !known {
+			return newError(http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records.")
+		}
+		mac := hmac.New(sha256.New, policy.DeriveSigningKey(value.SecretAccessKey, date, scope[2], scope[3]))
+		mac.Write([]byte(encoded))
+		if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(fields["x-amz-signature"])) {
+			return mismatch
+		}
+	} else {
+		id, found := fields["awsaccesskeyid"]
+		if !found {
+			return newError(http.StatusBadRequest, "InvalidArgument", "Bucket POST must contain a field named 'AWSAccessKeyId'.  If it is specified, please check the order of the fields.")
+		}
+		var known bool
+		if value, known = s.credentials[id]; !known {
+			return newError(http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records.")
+		}
+		mac := hmac.New(sha1.New, []byte(value.SecretAccessKey))
+		mac.Write([]byte(encoded))
+		if !hmac.Equal([]byte(base64.StdEncoding.EncodeToString(mac.Sum(nil))), []byte(fields["signature"])) {
+			return mismatch
+		}
+	}
+
+	if value.SessionToken != "" && fields["x-amz-security-token"] != value.SessionToken {
+		return newError(http.StatusForbidden, "InvalidToken", "The provided token is malformed or otherwise invalid.")
+	}
+	return nil
+}
+
+/*
+verifyDigests checks the file against the Content-MD5, x-amz-checksum-sha256
+and x-amz-checksum-crc32c fields, when they are sent.
+*/
+func verifyDigests(upload *form) error {
+	if expected, found := upload.fields["content-md5"]; found {
+		sum := md5.Sum(upload.file)
+		if expected != base64.StdEncoding.EncodeToString(sum[:]) {
+			return newError(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+		}
+	}
+	if expected, found := upload.fields["x-amz-checksum-sha256"]; found {
+		sum := sha256.Sum256(upload.file)
+		if expected != base64.StdEncoding.EncodeToString(sum[:]) {
+			return newError(http.StatusBadRequest, "BadDigest", "The SHA256 you specified did not match the calculated checksum.")
+		}
+	}
+	if expected, found := upload.fields["x-amz-checksum-crc32c"]; found {
+		sum := crc32.Checksum(upload.file, crc32.MakeTable(crc32.Castagnoli))
+		if expected != base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, sum)) {
+			return newError(http.StatusBadRequest, "BadDigest", "The CRC32C you specified did not match the calculated checksum.")
+		}
+	}
+	return nil
+}
+
+/*
+respond answers a stored upload: a 303 redirect to success_action_redirect
+with the bucket, key and etag in the query, or the success_action_status,
+204 by default.
+*/
+func respond(w http.ResponseWriter, fields map[string]string, object *Object, location string) {
+	etag := strconv.Quote(object.ETag)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Location", location)
+	for _, name := range []string{"x-amz-checksum-sha256", "x-amz-checksum-crc32c"} {
+		if checksum, found := fields[name]; found {
+			w.Header().Set(name, checksum)
+		}
+	}
+
+	redirect, found := fields["success_action_redirect"]
+	if !found {
+		redirect = fields["redirect"]
+	}
+	if target, ok := url.Parse(redirect); redirect != "" && ok == nil && target.IsAbs() {
+		query := target.Query()
+		query.Set("bucket", object.Bucket)
+		query.Set("key", object.Key)
+		query.Set("etag", etag)
+		target.RawQuery = query.Encode()
+		w.Header().Set("Location", target.String())
+		w.WriteHeader(http.StatusSeeOther)
+		return
+	}
+
+	switch fields["success_action_status"] {
+	case "200":
+		w.WriteHeader(http.StatusOK)
+	case "201":
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(postResponse{Location: location, Bucket: object.Bucket, Key: object.Key, ETag: etag})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Package s3test is an in-process S3 endpoint for testing uploads without
+AWS.  It implements POST Object the way S3 does: it decodes the policy,
+verifies its Signature Version 2 or 4 signature against the configured
+credentials, evaluates the conditions, stores the object and answers with
+S3's XML errors, redirects and status codes.
+
+	server := s3test.NewServer(s3test.WithCredentials(credentials.Value{AccessKeyId: "id", SecretAccessKey: "secret"}))
+	defer server.Close()
+	uploader, _ := transport.NewSingleFileUploader(policy, "file1.ext", file, transport.WithHTTPClient(server.Client()))
+
+Buckets are taken from the host name, bucket.s3.amazonaws.com, or the first
+element of the path, server.URL/bucket/, so uploads may use either
+addressing style.
+*/
+package s3test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+)
+
+/*
+Object is an object stored by the server.
+*/
+type Object struct {
+	Bucket string
+	Key    string
+	ETag   string
+	Size   int64
+
+	// Fields are the form fields posted with the file, by lower case name.
+	Fields map[string]string
+
+	body []byte
+	path string
+}
+
+/*
+Contents reads the stored object.
+*/
+func (o *Object) Contents() ([]byte, error) {
+	if o.path != "" {
+		return os.ReadFile(o.path)
+	}
+	return o.body, nil
+}
+
+/*
+Server is a fake S3 endpoint.  URL is its base URL, for use with path-style
+addressing.
+*/
+type Server struct {
+	URL string
+
+	server      *httptest.Server
+	mu          sync.Mutex
+	credentials map[string]credentials.Value
+	buckets     map[string]bool
+	objects     map[string]*Object
+	dir         string
+	now         func() time.Time
+	requests    int
+}
+
+/*
+Option configures a Server.
+*/
+type Option func(s *Server)
+
+/*
+WithCredentials accepts uploads signed by values.  A value with a session
+token also requires the x-amz-security-token field.
+*/
+func WithCredentials(values ...credentials.Value) Option {
+	return func(s *Server) {
+		for _, value := range values {
+			s.credentials[value.AccessKeyId] = value
+		}
+	}
+}
+
+/*
+WithBuckets limits the server to the named buckets; others are answered
+with NoSuchBucket.  By default every bucket exists.
+*/
+func WithBuckets(names ...string) Option {
+	return func(s *Server) {
+		for _, name := range names {
+			s.buckets[name] = true
+		}
+	}
+}
+
+/*
+WithDir stores objects below dir rather than in memory.
+*/
+func WithDir(dir string) Option {
+	return func(s *Server) {
+		s.dir = dir
+	}
+}
+
+/*
+WithClock sets the time policies expire against.
+*/
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+/*
+NewServer starts a server.  Close it when done.
+*/
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		credentials: map[string]credentials.Value{},
+		buckets:     map[string]bool{},
+		objects:     map[string]*Object{},
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+/*
+Client returns an http.Client that sends every request to the server,
+whatever the host in its URL, so uploads addressed to
+https://bucket.s3.amazonaws.com/ arrive with their original Host header.
+Redirects are not followed.
+*/
+func (s *Server) Client() *http.Client {
+	target, _ := url.Parse(s.URL)
+	return &http.Client{
+		Transport: &rerouteTransport{target: target, transport: s.server.Client().Transport},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+type rerouteTransport struct {
+	target    *url.URL
+	transport http.RoundTripper
+}
+
+func (r *rerouteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return r.transport.RoundTrip(req)
+}
+
+/*
+Object returns the object stored at bucket and key.
+*/
+func (s *Server) Object(bucket, key string) (object *Object, found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, found = s.objects[bucket+"/"+key]
+	return
+}
+
+/*
+Objects lists the stored objects ordered by bucket and key.
+*/
+func (s *Server) Objects() (objects []*Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, object := range s.objects {
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Bucket != objects[j].Bucket {
+			return objects[i].Bucket < objects[j].Bucket
+		}
+		return objects[i].Key < objects[j].Key
+	})
+	return
+}
+
+/*
+Requests counts the requests the server has received.
+*/
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) store(object *Object) (ok error) {
+	if s.dir != "" {
+		object.path = filepath.Join(s.dir, object.Bucket, url.PathEscape(object.Key))
+		if ok = os.MkdirAll(filepath.Dir(object.path), 0755); ok != nil {
+			return ok
+		}
+		if ok = os.WriteFile(object.path, object.body, 0644); ok != nil {
+			return ok
+		}
+		object.body = nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[object.Bucket+"/"+object.Key] = object
+	return nil
+}
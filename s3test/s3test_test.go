@@ -0,0 +1,271 @@
+package s3test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+)
+
+const (
+	test_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    ["content-length-range", 1, 16]
+  ]
+}`
+	redirect_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    ["starts-with", "$success_action_redirect", "http://example.com/"]
+  ]
+}`
+	status_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    ["starts-with", "$success_action_status", ""]
+  ]
+}`
+)
+
+func newTestServer(t *testing.T) *Server {
+	server := NewServer(
+		WithCredentials(credentials.Value{AccessKeyId: "foobar", SecretAccessKey: "barfoo"}),
+		WithClock(func() time.Time { return time.Date(2007, 11, 30, 0, 0, 0, 0, time.UTC) }))
+	t.Cleanup(server.Close)
+	return server
+}
+
+/*
+post sends fields, policyDoc signed by foobar and then file, followed by
+after, to the johnsmith bucket.  policyDoc is signed as written, so it need
+not be a policy the policy package accepts.
+*/
+func post(t *testing.T, server *Server, policyDoc string, fields [][2]string, file string, after ...[2]string) *http.Response {
+	encoded := base64.StdEncoding.EncodeToString([]byte(policyDoc))
+	mac := hmac.New(sha1.New, []byte("barfoo"))
+	mac.Write([]byte(encoded))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	fields = append(fields, [2]string{"AWSAccessKeyId", "foobar"}, [2]string{"policy", encoded}, [2]string{"signature", sig})
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, field := range fields {
+		writer.WriteField(field[0], field[1])
+	}
+	part, _ := writer.CreateFormFile("file", "file1.ext")
+	io.WriteString(part, file)
+	for _, field := range after {
+		writer.WriteField(field[0], field[1])
+	}
+	writer.Close()
+
+	request, _ := http.NewRequest("POST", "https://johnsmith.s3.amazonaws.com/", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	response, ok := server.Client().Do(request)
+	if ok != nil {
+		t.Fatalf("Request failed: %s", ok)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
+
+func checkError(t *testing.T, response *http.Response, status int, code string) {
+	var s3err s3Error
+	if ok := xml.NewDecoder(response.Body).Decode(&s3err); ok != nil {
+		t.Fatalf("Expected an XML error: %s", ok)
+	}
+	if response.StatusCode != status || s3err.Code != code || s3err.RequestId != response.Header.Get("x-amz-request-id") {
+		t.Errorf("Expected %d %s, got %d %+v", status, code, response.StatusCode, s3err)
+	}
+}
+
+func TestPostObject(t *testing.T) {
+	server := newTestServer(t)
+	response := post(t, server, test_policy, [][2]string{{"key", "user/eric/${filename}"}}, "file contents", [2]string{"ignored", "after file"})
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", response.StatusCode)
+	}
+	if response.Header.Get("ETag") != `"4a8ec4fa5f01b4ab1a0ab8cbccb709f0"` {
+		t.Errorf("Unexpected ETag: %s", response.Header.Get("ETag"))
+	}
+	if response.Header.Get("Location") != "https://johnsmith.s3.amazonaws.com/user/eric/file1.ext" {
+		t.Errorf("Unexpected Location: %s", response.Header.Get("Location"))
+	}
+	object, found := server.Object("johnsmith", "user/eric/file1.ext")
+	if !found {
+		t.Fatalf("${filename} should be replaced by the name of the file: %v", server.Objects())
+	}
+	if _, sent := object.Fields["ignored"]; sent {
+		t.Errorf("Fields after the file should be ignored")
+	}
+}
+
+func TestPostObjectRedirect(t *testing.T) {
+	server := newTestServer(t)
+	response := post(t, server, redirect_policy, [][2]string{{"key", "user/eric/a b.txt"}, {"success_action_redirect", "http://example.com/done?upload=1"}}, "contents")
+	if response.StatusCode != http.StatusSeeOther {
+		t.Fatalf("Expected 303, got %d", response.StatusCode)
+	}
+	location, _ := url.Parse(response.Header.Get("Location"))
+	query := location.Query()
+	if location.Host != "example.com" || query.Get("upload") != "1" || query.Get("bucket") != "johnsmith" ||
+		query.Get("key") != "user/eric/a b.txt" || query.Get("etag") != `"98bf7d8c15784f0a3d63204441e1e2aa"` {
+		t.Errorf("Unexpected redirect: %s", location)
+	}
+}
+
+func TestPostObjectStatus(t *testing.T) {
+	server := newTestServer(t)
+	if response := post(t, server, status_policy, [][2]string{{"key", "user/eric/a"}, {"success_action_status", "200"}}, "contents"); response.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", response.StatusCode)
+	}
+
+	response := post(t, server, status_policy, [][2]string{{"key", "user/eric/a"}, {"success_action_status", "201"}}, "contents")
+	var created postResponse
+	if ok := xml.NewDecoder(response.Body).Decode(&created); ok != nil || response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected a 201 PostResponse, got %d: %v", response.StatusCode, ok)
+	}
+	expected := postResponse{XMLName: xml.Name{Local: "PostResponse"}, Location: "https://johnsmith.s3.amazonaws.com/user/eric/a",
+		Bucket: "johnsmith", Key: "user/eric/a", ETag: `"98bf7d8c15784f0a3d63204441e1e2aa"`}
+	if created != expected {
+		t.Errorf("Unexpected PostResponse: %+v", created)
+	}
+}
+
+func TestDegeneratePostObject(t *testing.T) {
+	server := newTestServer(t)
+	checkError(t, post(t, server, test_policy, [][2]string{{"key", "other/a"}}, "contents"), http.StatusForbidden, "AccessDenied")
+	checkError(t, post(t, server, test_policy, [][2]string{{"key", "user/eric/a"}, {"acl", "public-read"}}, "contents"), http.StatusForbidden, "AccessDenied")
+	checkError(t, post(t, server, test_policy, [][2]string{{"key", "user/eric/a"}}, strings.Repeat("x", 17)), http.StatusBadRequest, "EntityTooLarge")
+	checkError(t, post(t, server, test_policy, [][2]string{{"key", "user/eric/a"}}, ""), http.StatusBadRequest, "EntityTooSmall")
+	if len(server.Objects()) != 0 {
+		t.Errorf("Rejected uploads should not be stored: %v", server.Objects())
+	}
+}
+
+func TestDegeneratePostObjectConditions(t *testing.T) {
+	server := newTestServer(t)
+	for _, test := range []struct {
+		condition string
+		fields    [][2]string
+		message   string
+	}{
+		{`{"bucket": "johnsmith"}, {"acl": "private"}`, [][2]string{{"acl", "public-read"}}, `Policy Condition failed: ["eq","$acl","private"]`},
+		{`{"bucket": "johnsmith"}, {"acl": "private"}`, nil, `Policy Condition failed: ["eq","$acl","private"]`},
+		{`{"bucket": "johnsmith"}, ["EQ", "$Content-Type", "text/plain"]`, [][2]string{{"Content-Type", "text/html"}}, `Policy Condition failed: ["eq","$content-type","text/plain"]`},
+		{`{"bucket": "johnsmith"}, ["starts-with", "$x-amz-meta-tag", "a"]`, [][2]string{{"x-amz-meta-tag", "b"}}, `Policy Condition failed: ["starts-with","$x-amz-meta-tag","a"]`},
+		{`{"bucket": "janesmith"}`, nil, `Policy Condition failed: ["eq","$bucket","janesmith"]`},
+		{`["starts-with", "$bucket", "jane"]`, [][2]string{{"bucket", "janesmith"}}, `Policy Condition failed: ["starts-with","$bucket","jane"]`},
+		{`{"bucket": "johnsmith"}`, [][2]string{{"x-amz-meta-tag", "a"}, {"acl", "public-read"}}, "Extra input fields: acl, x-amz-meta-tag"},
+	} {
+		doc := `{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [["starts-with", "$key", "user/eric/"], ` + test.condition + `]}`
+		response := post(t, server, doc, append([][2]string{{"key", "user/eric/a"}}, test.fields...), "contents")
+		var s3err s3Error
+		xml.NewDecoder(response.Body).Decode(&s3err)
+		if response.StatusCode != http.StatusForbidden || s3err.Code != "AccessDenied" || !strings.HasSuffix(s3err.Message, test.message) {
+			t.Errorf("%s: Expected AccessDenied with %q, got %d %+v", test.condition, test.message, response.StatusCode, s3err)
+		}
+	}
+
+	accepted := `{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [["Starts-With", "$Key", "user/eric/"], {"$bucket": "johnsmith"},
+  ["eq", "$acl", "public-read"], ["starts-with", "$x-amz-meta-tag", ""], ["content-length-range", 8, 8]]}`
+	fields := [][2]string{{"key", "user/eric/a"}, {"ACL", "public-read"}, {"x-amz-meta-tag", "any"}, {"x-ignore-me", "1"}}
+	if response := post(t, server, accepted, fields, "contents"); response.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(response.Body)
+		t.Errorf("Expected the upload to satisfy the policy, got %d: %s", response.StatusCode, body)
+	}
+}
+
+func TestDegenerateInvalidPolicy(t *testing.T) {
+	server := newTestServer(t)
+	for _, doc := range []string{
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [["starts-with", "$key", "user/eric/"], ["ends-with", "$key", ".txt"]]}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [["starts-with", "$key", "user/eric/"], ["x-amz-meta-size", 1, 16]]}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [["starts-with", "$key"]]}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [["starts-with", "key", "user/eric/"]]}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [{"key": "user/eric/a", "acl": "private"}]}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [{"key": 1}]}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [["content-length-range", 16, 1]]}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": ["key"]}`,
+		`{"expiration": "tomorrow", "conditions": [{"key": "user/eric/a"}]}`,
+		`{"conditions": [{"key": "user/eric/a"}]}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z"}`,
+		`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [{"key": "user/eric/a"}], "bucket": "johnsmith"}`,
+	} {
+		response := post(t, server, doc, [][2]string{{"key", "user/eric/a"}}, "contents")
+		var s3err s3Error
+		xml.NewDecoder(response.Body).Decode(&s3err)
+		if response.StatusCode != http.StatusBadRequest || s3err.Code != "InvalidPolicyDocument" {
+			t.Errorf("%s: Expected InvalidPolicyDocument, got %d %+v", doc, response.StatusCode, s3err)
+		}
+	}
+}
+
+func TestDegenerateRequests(t *testing.T) {
+	server := newTestServer(t)
+	client := server.Client()
+
+	response, ok := client.Get(server.URL + "/johnsmith/")
+	if ok != nil {
+		t.Fatalf("Request failed: %s", ok)
+	}
+	defer response.Body.Close()
+	checkError(t, response, http.StatusMethodNotAllowed, "MethodNotAllowed")
+
+	response, ok = client.Post(server.URL+"/johnsmith/", "application/x-www-form-urlencoded", strings.NewReader("key=a"))
+	if ok != nil {
+		t.Fatalf("Request failed: %s", ok)
+	}
+	defer response.Body.Close()
+	checkError(t, response, http.StatusPreconditionFailed, "PreconditionFailed")
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("key", "user/eric/a")
+	writer.Close()
+	response, ok = client.Post(server.URL+"/johnsmith/", writer.FormDataContentType(), &body)
+	if ok != nil {
+		t.Fatalf("Request failed: %s", ok)
+	}
+	defer response.Body.Close()
+	checkError(t, response, http.StatusBadRequest, "InvalidArgument")
+}
+
+func TestDegenerateBucketName(t *testing.T) {
+	dir := t.TempDir()
+	server := NewServer(WithDir(filepath.Join(dir, "objects")))
+	t.Cleanup(server.Close)
+	client := server.Client()
+
+	for _, path := range []string{"/../", "/..%2F..%2Fescaped/", "/Upper_Case/", "/ab/", "/192.168.1.1/", "/a..b/"} {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("key", "escaped")
+		writer.Close()
+		response, ok := client.Post(server.URL+path, writer.FormDataContentType(), &body)
+		if ok != nil {
+			t.Fatalf("Request failed: %s", ok)
+		}
+		defer response.Body.Close()
+		checkError(t, response, http.StatusBadRequest, "InvalidBucketName")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Nothing should be written outside the objects directory: %v", entries)
+	}
+}
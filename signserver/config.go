@@ -0,0 +1,117 @@
+package signserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+/*
+Config is the JSON configuration of a Server: the bucket uploads go to and
+the rule of every caller.
+
+	{
+	  "bucket": "my-s3dropbox",
+	  "callers": [
+	    {"name": "eric", "token": "...", "key_prefix": "uploads/eric/",
+	     "max_size": "10MB", "content_types": ["image/"],
+	     "expires_in": "15m", "rate_limit": 60}
+	  ]
+	}
+*/
+type Config struct {
+	Bucket  string
+	Callers []Caller
+}
+
+/*
+Caller is a token and the Rule of the requests made with it.
+*/
+type Caller struct {
+	Token string
+	Rule
+}
+
+type configDocument struct {
+	Bucket  string `json:"bucket"`
+	Callers []struct {
+		Name         string      `json:"name"`
+		Token        string      `json:"token"`
+		KeyPrefix    string      `json:"key_prefix"`
+		MaxSize      interface{} `json:"max_size"`
+		ContentTypes []string    `json:"content_types"`
+		ExpiresIn    string      `json:"expires_in"`
+		RateLimit    int         `json:"rate_limit"`
+	} `json:"callers"`
+}
+
+/*
+ParseConfig reads and checks a JSON configuration.  max_size may be a number
+of bytes or a size such as 10MB.
+*/
+func ParseConfig(doc []byte) (config *Config, ok error) {
+	var document configDocument
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if ok = decoder.Decode(&document); ok != nil {
+		return nil, fmt.Errorf("Invalid configuration: %s", ok)
+	}
+	if document.Bucket == "" {
+		return nil, fmt.Errorf("The configuration requires a bucket.")
+	}
+	if len(document.Callers) == 0 {
+		return nil, fmt.Errorf("The configuration requires at least one caller.")
+	}
+
+	config = &Config{Bucket: document.Bucket}
+	tokens := map[string]bool{}
+	for i, caller := range document.Callers {
+		name := caller.Name
+		if name == "" {
+			name = fmt.Sprintf("callers[%d]", i)
+		}
+		switch {
+		case caller.Token == "":
+			return nil, fmt.Errorf("Caller %s requires a token.", name)
+		case tokens[caller.Token]:
+			return nil, fmt.Errorf("Caller %s reuses the token of another caller.", name)
+		case caller.KeyPrefix == "":
+			return nil, fmt.Errorf("Caller %s requires a key_prefix.", name)
+		case caller.MaxSize == nil:
+			return nil, fmt.Errorf("Caller %s requires a max_size.", name)
+		case caller.RateLimit < 0:
+			return nil, fmt.Errorf("The rate_limit of caller %s can not be negative.", name)
+		}
+		tokens[caller.Token] = true
+
+		rule := Rule{Name: name, KeyPrefix: caller.KeyPrefix, ContentTypes: caller.ContentTypes, RateLimit: caller.RateLimit}
+		if rule.MaxSize, ok = policy.ParseSize(fmt.Sprint(caller.MaxSize)); ok != nil {
+			return nil, fmt.Errorf("Caller %s: %s", name, ok)
+		}
+		if rule.MaxSize <= 0 {
+			return nil, fmt.Errorf("The max_size of caller %s must be positive.", name)
+		}
+		if caller.ExpiresIn != "" {
+			if rule.ExpiresIn, ok = policy.ParseExpiresIn(caller.ExpiresIn); ok != nil {
+				return nil, fmt.Errorf("Caller %s: %s", name, ok)
+			}
+		} else {
+			rule.ExpiresIn = policy.DefaultExpiresIn
+		}
+		config.Callers = append(config.Callers, Caller{caller.Token, rule})
+	}
+	return config, nil
+}
+
+/*
+Options are the WithCaller options of every caller.
+*/
+func (c *Config) Options() (opts []Option) {
+	for _, caller := range c.Callers {
+		opts = append(opts, WithCaller(caller.Token, caller.Rule))
+	}
+	return
+}
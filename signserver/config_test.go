@@ -0,0 +1,57 @@
+package signserver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+const test_config = `{
+  "bucket": "johnsmith",
+  "callers": [
+    {"name": "eric", "token": "eric-token", "key_prefix": "user/eric/", "max_size": "10MB",
+     "content_types": ["image/"], "expires_in": "15m", "rate_limit": 60},
+    {"token": "bob-token", "key_prefix": "user/bob/", "max_size": 1024}
+  ]
+}`
+
+func TestParseConfig(t *testing.T) {
+	config, ok := ParseConfig([]byte(test_config))
+	if ok != nil {
+		t.Fatalf("Unable to parse the configuration: %s", ok)
+	}
+	expected := &Config{Bucket: "johnsmith", Callers: []Caller{
+		{"eric-token", Rule{Name: "eric", KeyPrefix: "user/eric/", MaxSize: 10 << 20, ContentTypes: []string{"image/"}, ExpiresIn: 15 * time.Minute, RateLimit: 60}},
+		{"bob-token", Rule{Name: "callers[1]", KeyPrefix: "user/bob/", MaxSize: 1024, ExpiresIn: policy.DefaultExpiresIn}},
+	}}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Unexpected configuration.  Expected:\n%+v\nActual:\n%+v", expected, config)
+	}
+	if len(config.Options()) != 2 {
+		t.Errorf("Expected an option per caller")
+	}
+}
+
+func TestDegenerateParseConfig(t *testing.T) {
+	for doc, message := range map[string]string{
+		`{`:               "Invalid configuration",
+		`{"callers": []}`: "requires a bucket",
+		`{"bucket": "b"}`: "at least one caller",
+		`{"bucket": "b", "callers": [{"key_prefix": "k/", "max_size": 1}]}`:                                                                  "requires a token",
+		`{"bucket": "b", "callers": [{"token": "t", "max_size": 1}]}`:                                                                        "requires a key_prefix",
+		`{"bucket": "b", "callers": [{"token": "t", "key_prefix": "k/"}]}`:                                                                   "requires a max_size",
+		`{"bucket": "b", "callers": [{"token": "t", "key_prefix": "k/", "max_size": "big"}]}`:                                                "Invalid size",
+		`{"bucket": "b", "callers": [{"token": "t", "key_prefix": "k/", "max_size": 0}]}`:                                                    "must be positive",
+		`{"bucket": "b", "callers": [{"token": "t", "key_prefix": "k/", "max_size": 1, "expires_in": "soon"}]}`:                              "Invalid duration",
+		`{"bucket": "b", "callers": [{"token": "t", "key_prefix": "k/", "max_size": 1, "rate_limit": -1}]}`:                                  "can not be negative",
+		`{"bucket": "b", "callers": [{"token": "t", "key_prefix": "k/", "max_size": 1}, {"token": "t", "key_prefix": "j/", "max_size": 1}]}`: "reuses the token",
+		`{"bucket": "b", "callers": [], "region": "us-east-1"}`:                                                                              "unknown field",
+	} {
+		if _, ok := ParseConfig([]byte(doc)); ok == nil || !strings.Contains(ok.Error(), message) {
+			t.Errorf("%s: Expected an error containing %q, got %v", doc, message, ok)
+		}
+	}
+}
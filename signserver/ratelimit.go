@@ -0,0 +1,103 @@
+package signserver
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+rateLimiter is a token bucket per caller.  Each bucket holds up to limit
+requests and refills at limit per interval, so a caller may spend a whole
+interval's requests at once and then continues at the steady rate.
+*/
+type rateLimiter struct {
+	interval  time.Duration
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(interval time.Duration) *rateLimiter {
+	return &rateLimiter{interval: interval, buckets: map[string]*tokenBucket{}}
+}
+
+/*
+allow takes a request from caller's bucket.  When the bucket is empty it
+reports how long until the next request is allowed.  A limit of 0 allows
+every request.
+*/
+func (r *rateLimiter) allow(caller string, limit int, now time.Time) (allowed bool, retryAfter time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket := r.refill(caller, limit, now)
+	if bucket.tokens < 1 {
+		return false, r.wait(bucket, limit)
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+/*
+exhausted reports whether caller's bucket is empty without taking a
+request from it, and how long until it is not.
+*/
+func (r *rateLimiter) exhausted(caller string, limit int, now time.Time) (empty bool, retryAfter time.Duration) {
+	if limit <= 0 {
+		return false, 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket := r.refill(caller, limit, now)
+	if bucket.tokens < 1 {
+		return true, r.wait(bucket, limit)
+	}
+	return false, 0
+}
+
+/*
+refill returns caller's bucket topped up for the time since it was last
+used.  Buckets left alone for an interval are full again and are dropped,
+so callers that come and go, such as remote addresses, do not accumulate.
+*/
+func (r *rateLimiter) refill(caller string, limit int, now time.Time) *tokenBucket {
+	if now.Sub(r.lastSweep) >= r.interval {
+		for name, bucket := range r.buckets {
+			if now.Sub(bucket.last) >= r.interval {
+				delete(r.buckets, name)
+			}
+		}
+		r.lastSweep = now
+	}
+
+	bucket, found := r.buckets[caller]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(limit), last: now}
+		r.buckets[caller] = bucket
+	}
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens += float64(elapsed) * r.rate(limit)
+		if bucket.tokens > float64(limit) {
+			bucket.tokens = float64(limit)
+		}
+		bucket.last = now
+	}
+	return bucket
+}
+
+func (r *rateLimiter) rate(limit int) float64 {
+	return float64(limit) / float64(r.interval)
+}
+
+func (r *rateLimiter) wait(bucket *tokenBucket, limit int) time.Duration {
+	return time.Duration((1 - bucket.tokens) / r.rate(limit))
+}
@@ -0,0 +1,54 @@
+package signserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(time.Minute)
+	now := time.Date(2007, 11, 30, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.allow("eric", 3, now); !allowed {
+			t.Fatalf("Request %d should be within the burst", i)
+		}
+	}
+	if allowed, retryAfter := limiter.allow("eric", 3, now); allowed || retryAfter != 20*time.Second {
+		t.Errorf("Expected to wait 20s, got %v %s", allowed, retryAfter)
+	}
+	if allowed, _ := limiter.allow("bob", 3, now); !allowed {
+		t.Errorf("Callers should have their own buckets")
+	}
+	if allowed, _ := limiter.allow("eric", 3, now.Add(20*time.Second)); !allowed {
+		t.Errorf("The bucket should refill")
+	}
+	if allowed, _ := limiter.allow("eric", 3, now.Add(time.Hour)); !allowed {
+		t.Errorf("The bucket should refill")
+	}
+	for i := 0; i < 100; i++ {
+		if allowed, _ := limiter.allow("mallory", 0, now); !allowed {
+			t.Fatalf("A limit of 0 should allow every request")
+		}
+	}
+}
+
+func TestRateLimiterExhausted(t *testing.T) {
+	limiter := newRateLimiter(time.Minute)
+	now := time.Date(2007, 11, 30, 0, 0, 0, 0, time.UTC)
+	if exhausted, _ := limiter.exhausted("127.0.0.1", 1, now); exhausted {
+		t.Errorf("A new bucket should not be exhausted")
+	}
+	limiter.allow("127.0.0.1", 1, now)
+	if exhausted, retryAfter := limiter.exhausted("127.0.0.1", 1, now); !exhausted || retryAfter != time.Minute {
+		t.Errorf("Expected to wait a minute, got %v %s", exhausted, retryAfter)
+	}
+	if exhausted, _ := limiter.exhausted("127.0.0.1", 1, now); !exhausted {
+		t.Errorf("Checking should not take a request")
+	}
+
+	limiter.allow("127.0.0.2", 1, now.Add(time.Second))
+	limiter.allow("127.0.0.3", 1, now.Add(time.Minute))
+	if len(limiter.buckets) != 2 {
+		t.Errorf("Buckets full again should be dropped: %v", limiter.buckets)
+	}
+}
@@ -0,0 +1,382 @@
+/*
+Package signserver signs S3 POST policies for browser uploads over HTTP.
+
+A client holding a bearer token asks for a policy for one file:
+
+	POST /sign
+	Authorization: Bearer <token>
+
+	{"filename": "photo.jpg", "size": 48213, "content_type": "image/jpeg"}
+
+The server checks the request against the Rule of that token, signs a policy
+for exactly that key, size and content type, and answers with the upload
+form as formgen describes it: the action URL and the fields to post before
+the file.  GET /healthz answers ok while the server is up.
+*/
+package signserver
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/formgen"
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+/*
+maxRequestSize bounds the body of a sign request.
+*/
+const maxRequestSize = 1 << 16
+
+/*
+Rule constrains the policies signed for one caller.  Every key starts with
+KeyPrefix, no file is larger than MaxSize bytes and, when ContentTypes is
+not empty, every content type starts with one of them, e.g. image/.
+Policies expire after ExpiresIn, or policy.DefaultExpiresIn, unless the
+request asks for less.  RateLimit is the number of requests allowed a
+minute; 0 is unlimited.
+*/
+type Rule struct {
+	Name         string
+	KeyPrefix    string
+	MaxSize      int64
+	ContentTypes []string
+	ExpiresIn    time.Duration
+	RateLimit    int
+}
+
+/*
+DefaultAuthFailureLimit is the number of requests with a missing or unknown
+token allowed from one remote address a minute unless WithAuthFailureLimit
+is given.
+*/
+const DefaultAuthFailureLimit = 10
+
+/*
+SignRequest is the body of POST /sign.  ExpiresIn, e.g. 5m, shortens the
+caller's expiration for this policy.
+*/
+type SignRequest struct {
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	ExpiresIn   string `json:"expires_in,omitempty"`
+}
+
+/*
+Server answers sign requests for the callers added with WithCaller.
+*/
+type Server struct {
+	bucket           string
+	credentials      credentials.Credentials
+	callers          map[[sha256.Size]byte]Rule
+	now              func() time.Time
+	transportOptions []transport.Option
+	errorLog         *log.Logger
+	limiter          *rateLimiter
+	failures         *rateLimiter
+	failureLimit     int
+	mux              *http.ServeMux
+}
+
+/*
+Option configures a Server.
+*/
+type Option func(s *Server)
+
+/*
+WithCaller accepts requests with the bearer token and constrains them by
+rule.
+*/
+func WithCaller(token string, rule Rule) Option {
+	return func(s *Server) {
+		s.callers[sha256.Sum256([]byte(token))] = rule
+	}
+}
+
+/*
+WithClock sets the time policies expire from, are signed at and rate limits
+refill by.
+*/
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+/*
+WithTransportOptions signs the forms with transport.WithSignatureVersion and
+WithRegion, and chooses their action URL with WithEndpoint, WithPathStyle,
+WithDualStack or WithAccelerate.
+*/
+func WithTransportOptions(opts ...transport.Option) Option {
+	return func(s *Server) {
+		s.transportOptions = append(s.transportOptions, opts...)
+	}
+}
+
+/*
+WithErrorLog logs failures to sign, which callers only see as an internal
+error, to l rather than the standard logger.
+*/
+func WithErrorLog(l *log.Logger) Option {
+	return func(s *Server) {
+		s.errorLog = l
+	}
+}
+
+/*
+WithAuthFailureLimit replaces DefaultAuthFailureLimit.  Once an address has
+used up its failures, its requests are refused before their token is
+checked, so tokens can not be guessed quickly.  0 is unlimited.
+*/
+func WithAuthFailureLimit(limit int) Option {
+	return func(s *Server) {
+		s.failureLimit = limit
+	}
+}
+
+/*
+New returns a Server that signs policies for uploads to bucket with creds,
+retrieved for every request so temporary credentials can be refreshed.
+*/
+func New(bucket string, creds credentials.Credentials, opts ...Option) *Server {
+	s := &Server{
+		bucket:       bucket,
+		credentials:  creds,
+		callers:      map[[sha256.Size]byte]Rule{},
+		now:          time.Now,
+		errorLog:     log.Default(),
+		limiter:      newRateLimiter(time.Minute),
+		failures:     newRateLimiter(time.Minute),
+		failureLimit: DefaultAuthFailureLimit,
+		mux:          http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mux.HandleFunc("/healthz", s.healthz)
+	s.mux.HandleFunc("/sign", s.signHandler)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+/*
+requestError is a failed request and the status it is answered with.
+*/
+type requestError struct {
+	status  int
+	message string
+}
+
+func (e *requestError) Error() string {
+	return e.message
+}
+
+func newError(status int, format string, args ...interface{}) *requestError {
+	return &requestError{status, fmt.Sprintf(format, args...)}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, e *requestError) {
+	writeJSON(w, e.status, map[string]string{"error": e.message})
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, newError(http.StatusMethodNotAllowed, "Use GET."))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+func (s *Server) signHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		writeError(w, newError(http.StatusMethodNotAllowed, "Use POST."))
+		return
+	}
+	now := s.now()
+	address := remoteAddress(r)
+	if exhausted, retryAfter := s.failures.exhausted(address, s.failureLimit, now); exhausted {
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+	caller, rule, e := s.authenticate(r)
+	if e != nil {
+		s.failures.allow(address, s.failureLimit, now)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="s3dropbox"`)
+		writeError(w, e)
+		return
+	}
+	if allowed, retryAfter := s.limiter.allow(caller, rule.RateLimit, now); !allowed {
+		writeTooManyRequests(w, retryAfter)
+		return
+	}
+
+	var request SignRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	if ok := decoder.Decode(&request); ok != nil {
+		writeError(w, newError(http.StatusBadRequest, "Invalid sign request: %s", ok))
+		return
+	}
+	p, e := s.buildPolicy(rule, request, now)
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+
+	form, ok := s.sign(p, now)
+	if ok != nil {
+		s.errorLog.Printf("signserver: Unable to sign a policy for %s: %s", rule.Name, ok)
+		writeError(w, newError(http.StatusInternalServerError, "Unable to sign the policy."))
+		return
+	}
+	writeJSON(w, http.StatusOK, form)
+}
+
+func writeTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, newError(http.StatusTooManyRequests, "Too many requests.  Try again in %s.", retryAfter.Round(time.Second)))
+}
+
+/*
+remoteAddress is the host the request came from, without its port.
+*/
+func remoteAddress(r *http.Request) string {
+	if host, _, ok := net.SplitHostPort(r.RemoteAddr); ok == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func (s *Server) sign(p *policy.Policy, now time.Time) (*formgen.Form, error) {
+	creds, ok := s.credentials.Retrieve()
+	if ok != nil {
+		return nil, ok
+	}
+	opts := s.transportOptions[:len(s.transportOptions):len(s.transportOptions)]
+	opts = append(opts, transport.WithClock(func() time.Time { return now }))
+	return formgen.New(p, creds, formgen.WithTransportOptions(opts...))
+}
+
+/*
+authenticate finds the rule of the request's bearer token.  Tokens are
+looked up by their SHA-256 so the lookup reveals nothing about them, and the
+caller is named by that hash so the token is not kept in memory.
+*/
+func (s *Server) authenticate(r *http.Request) (caller string, rule Rule, e *requestError) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", Rule{}, newError(http.StatusUnauthorized, "A bearer token is required.")
+	}
+	digest := sha256.Sum256([]byte(token))
+	rule, found = s.callers[digest]
+	if !found {
+		return "", Rule{}, newError(http.StatusUnauthorized, "Unknown token.")
+	}
+	return string(digest[:]), rule, nil
+}
+
+/*
+buildPolicy checks request against rule and builds a policy for exactly the
+file requested.
+*/
+func (s *Server) buildPolicy(rule Rule, request SignRequest, now time.Time) (*policy.Policy, *requestError) {
+	if ok := checkFilename(request.Filename); ok != nil {
+		return nil, newError(http.StatusBadRequest, "%s", ok)
+	}
+	switch {
+	case request.Size <= 0:
+		return nil, newError(http.StatusBadRequest, "The size of the file is required.")
+	case rule.MaxSize > 0 && request.Size > rule.MaxSize:
+		return nil, newError(http.StatusForbidden, "The file is larger than the %d bytes allowed.", rule.MaxSize)
+	}
+	if len(rule.ContentTypes) > 0 && !allowedContentType(rule.ContentTypes, request.ContentType) {
+		return nil, newError(http.StatusForbidden, "The content_type must start with %s.", strings.Join(rule.ContentTypes, " or "))
+	}
+
+	expiresIn := rule.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = policy.DefaultExpiresIn
+	}
+	if request.ExpiresIn != "" {
+		requested, ok := policy.ParseExpiresIn(request.ExpiresIn)
+		if ok != nil {
+			return nil, newError(http.StatusBadRequest, "%s", ok)
+		}
+		if requested > expiresIn {
+			return nil, newError(http.StatusForbidden, "The policy may expire in at most %s.", expiresIn)
+		}
+		expiresIn = requested
+	}
+
+	p, ok := policy.NewPolicy(now.Add(expiresIn))
+	if ok != nil {
+		return nil, newError(http.StatusInternalServerError, "%s", ok)
+	}
+	p.AddConditionEq("bucket", s.bucket)
+	p.AddConditionEq("key", rule.KeyPrefix+request.Filename)
+	if request.ContentType != "" {
+		p.AddConditionEq("Content-Type", request.ContentType)
+	}
+	p.AddConditionRange("content-length-range", float64(request.Size), float64(request.Size))
+	return p, nil
+}
+
+/*
+checkFilename accepts a plain file name, which can not leave the caller's
+key prefix.
+*/
+func checkFilename(name string) error {
+	switch {
+	case name == "":
+		return errors.New("The filename is required.")
+	case name == "." || name == ".." || strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("Invalid filename %q.  Expected a name without a directory.", name)
+	case len(name) > 255:
+		return errors.New("The filename is longer than 255 bytes.")
+	}
+	for _, r := range name {
+		if r < ' ' || r == 0x7f {
+			return fmt.Errorf("Invalid filename %q.  Control characters are not allowed.", name)
+		}
+	}
+	return nil
+}
+
+func allowedContentType(allowed []string, contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	for _, prefix := range allowed {
+		if strings.HasPrefix(strings.ToLower(contentType), strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package signserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/formgen"
+	"github.com/noahcampbell/s3dropbox/policy"
+	"github.com/noahcampbell/s3dropbox/s3test"
+	"github.com/noahcampbell/s3dropbox/transport"
+)
+
+const test_token = "eric-token"
+
+var (
+	test_date = time.Date(2007, 11, 30, 0, 0, 0, 0, time.UTC)
+	test_rule = Rule{
+		Name:         "eric",
+		KeyPrefix:    "user/eric/",
+		MaxSize:      1024,
+		ContentTypes: []string{"text/"},
+		ExpiresIn:    15 * time.Minute,
+		RateLimit:    2,
+	}
+)
+
+/*
+newTestServer serves a Server for the johnsmith bucket, signing with
+foobar/barfoo, whose clock is advanced by setting *now.
+*/
+func newTestServer(t *testing.T, opts ...Option) (server *httptest.Server, now *time.Time) {
+	now = new(time.Time)
+	*now = test_date
+	opts = append([]Option{
+		WithCaller(test_token, test_rule),
+		WithClock(func() time.Time { return *now }),
+		WithErrorLog(log.New(io.Discard, "", 0)),
+	}, opts...)
+	server = httptest.NewServer(New("johnsmith", credentials.NewStatic("foobar", "barfoo", ""), opts...))
+	t.Cleanup(server.Close)
+	return server, now
+}
+
+func signRequest(t *testing.T, server *httptest.Server, token, body string) *http.Response {
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/sign", strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, ok := server.Client().Do(request)
+	if ok != nil {
+		t.Fatalf("Request failed: %s", ok)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
+
+func checkStatus(t *testing.T, response *http.Response, status int) {
+	t.Helper()
+	if response.StatusCode != status {
+		body, _ := io.ReadAll(response.Body)
+		t.Fatalf("Expected status %d, got %d: %s", status, response.StatusCode, body)
+	}
+}
+
+func TestSign(t *testing.T) {
+	server, _ := newTestServer(t)
+	response := signRequest(t, server, test_token, `{"filename": "file1.ext", "size": 13, "content_type": "text/plain", "expires_in": "5m"}`)
+	checkStatus(t, response, http.StatusOK)
+	if response.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("Signed forms should not be cached: %v", response.Header)
+	}
+	var form formgen.Form
+	if ok := json.NewDecoder(response.Body).Decode(&form); ok != nil {
+		t.Fatalf("Invalid response: %s", ok)
+	}
+	if form.Action != "https://johnsmith.s3.amazonaws.com/" || !form.Expiration.Equal(test_date.Add(5*time.Minute)) {
+		t.Errorf("Unexpected form: %+v", form)
+	}
+
+	var fields []transport.FormField
+	var encoded string
+	for _, field := range form.Fields {
+		fields = append(fields, transport.FormField{Name: field.Name, Value: field.Value})
+		if field.Name == "policy" {
+			encoded = field.Value
+		}
+	}
+	p := decodePolicy(t, encoded)
+	for _, expected := range [][2]string{{"bucket", "johnsmith"}, {"key", "user/eric/file1.ext"}, {"Content-Type", "text/plain"}} {
+		if condition, found := p.Condition(expected[0]); !found || condition.ValueString() != expected[1] {
+			t.Errorf("Expected the condition %s=%s: %v", expected[0], expected[1], p.Conditions)
+		}
+	}
+
+	s3 := s3test.NewServer(
+		s3test.WithCredentials(credentials.Value{AccessKeyId: "foobar", SecretAccessKey: "barfoo"}),
+		s3test.WithClock(func() time.Time { return test_date }))
+	defer s3.Close()
+	raw, _ := p.Canonical()
+	uploader, ok := transport.NewSingleFileUploader(bytes.NewReader(raw), "file1.ext", strings.NewReader("file contents"),
+		transport.WithPresignedForm(form.Action, fields), transport.WithHTTPClient(s3.Client()))
+	if ok != nil {
+		t.Fatalf("Unable to create an uploader: %s", ok)
+	}
+	if _, ok = uploader.Upload(context.Background()); ok != nil {
+		t.Fatalf("The signed form should upload: %s", ok)
+	}
+	if _, found := s3.Object("johnsmith", "user/eric/file1.ext"); !found {
+		t.Errorf("Object not stored: %v", s3.Objects())
+	}
+}
+
+func decodePolicy(t *testing.T, encoded string) *policy.Policy {
+	raw, ok := base64.StdEncoding.DecodeString(encoded)
+	if ok != nil {
+		t.Fatalf("Invalid policy field %q: %s", encoded, ok)
+	}
+	p, ok := policy.ParsePolicy(raw)
+	if ok != nil {
+		t.Fatalf("Invalid policy %s: %s", raw, ok)
+	}
+	return p
+}
+
+func TestSignV4(t *testing.T) {
+	server, _ := newTestServer(t, WithTransportOptions(transport.WithSignatureVersion(transport.SignatureV4), transport.WithRegion("eu-west-1")))
+	response := signRequest(t, server, test_token, `{"filename": "notes.txt", "size": 10, "content_type": "text/plain"}`)
+	checkStatus(t, response, http.StatusOK)
+	var form formgen.Form
+	json.NewDecoder(response.Body).Decode(&form)
+	values := map[string]string{}
+	for _, field := range form.Fields {
+		values[field.Name] = field.Value
+	}
+	if values["x-amz-date"] != "20071130T000000Z" || values["x-amz-credential"] != "foobar/20071130/eu-west-1/s3/aws4_request" || values["x-amz-signature"] == "" {
+		t.Errorf("The form should be signed at the server's clock: %v", values)
+	}
+}
+
+func TestSignDefaultExpiration(t *testing.T) {
+	server, _ := newTestServer(t)
+	response := signRequest(t, server, test_token, `{"filename": "notes.txt", "size": 10, "content_type": "text/plain"}`)
+	checkStatus(t, response, http.StatusOK)
+	var form formgen.Form
+	json.NewDecoder(response.Body).Decode(&form)
+	if !form.Expiration.Equal(test_date.Add(test_rule.ExpiresIn)) {
+		t.Errorf("The policy should expire after the rule's expires_in: %s", form.Expiration)
+	}
+}
+
+func TestSignRateLimit(t *testing.T) {
+	server, now := newTestServer(t)
+	body := `{"filename": "notes.txt", "size": 10, "content_type": "text/plain"}`
+	for i := 0; i < test_rule.RateLimit; i++ {
+		checkStatus(t, signRequest(t, server, test_token, body), http.StatusOK)
+	}
+	response := signRequest(t, server, test_token, body)
+	checkStatus(t, response, http.StatusTooManyRequests)
+	if retryAfter := response.Header.Get("Retry-After"); retryAfter != "30" {
+		t.Errorf("Expected to retry after 30 seconds, got %q", retryAfter)
+	}
+
+	*now = now.Add(30 * time.Second)
+	checkStatus(t, signRequest(t, server, test_token, body), http.StatusOK)
+	checkStatus(t, signRequest(t, server, test_token, body), http.StatusTooManyRequests)
+}
+
+func TestDegenerateSignAuthFailureLimit(t *testing.T) {
+	server, now := newTestServer(t, WithAuthFailureLimit(2))
+	body := `{"filename": "notes.txt", "size": 10, "content_type": "text/plain"}`
+	checkStatus(t, signRequest(t, server, "mallory-token", body), http.StatusUnauthorized)
+	checkStatus(t, signRequest(t, server, "", body), http.StatusUnauthorized)
+	checkStatus(t, signRequest(t, server, "mallory-token", body), http.StatusTooManyRequests)
+	// The address is refused before its token is checked.
+	checkStatus(t, signRequest(t, server, test_token, body), http.StatusTooManyRequests)
+
+	*now = now.Add(30 * time.Second)
+	checkStatus(t, signRequest(t, server, test_token, body), http.StatusOK)
+	checkStatus(t, signRequest(t, server, test_token, body), http.StatusOK)
+}
+
+func TestHealthz(t *testing.T) {
+	server, _ := newTestServer(t)
+	response, ok := server.Client().Get(server.URL + "/healthz")
+	if ok != nil {
+		t.Fatalf("Request failed: %s", ok)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "ok\n" {
+		t.Errorf("Unexpected health check: %d %q", response.StatusCode, body)
+	}
+}
+
+func TestDegenerateSign(t *testing.T) {
+	// Rejected requests count towards the rate limit too.
+	unlimited := test_rule
+	unlimited.RateLimit = 0
+	server, _ := newTestServer(t, WithCaller(test_token, unlimited))
+	for _, test := range []struct {
+		token  string
+		body   string
+		status int
+	}{
+		{"", `{"filename": "notes.txt", "size": 10, "content_type": "text/plain"}`, http.StatusUnauthorized},
+		{"mallory-token", `{"filename": "notes.txt", "size": 10, "content_type": "text/plain"}`, http.StatusUnauthorized},
+		{test_token, `{"filename": "notes.txt", "size": 10`, http.StatusBadRequest},
+		{test_token, `{"filename": "notes.txt", "size": 10, "content_type": "text/plain", "acl": "public-read"}`, http.StatusBadRequest},
+		{test_token, `{"filename": "../bob/notes.txt", "size": 10, "content_type": "text/plain"}`, http.StatusBadRequest},
+		{test_token, `{"filename": "..", "size": 10, "content_type": "text/plain"}`, http.StatusBadRequest},
+		{test_token, `{"filename": "notes\n.txt", "size": 10, "content_type": "text/plain"}`, http.StatusBadRequest},
+		{test_token, `{"filename": "notes.txt", "content_type": "text/plain"}`, http.StatusBadRequest},
+		{test_token, `{"filename": "notes.txt", "size": 2048, "content_type": "text/plain"}`, http.StatusForbidden},
+		{test_token, `{"filename": "photo.jpg", "size": 10, "content_type": "image/jpeg"}`, http.StatusForbidden},
+		{test_token, `{"filename": "notes.txt", "size": 10}`, http.StatusForbidden},
+		{test_token, `{"filename": "notes.txt", "size": 10, "content_type": "text/plain", "expires_in": "1h"}`, http.StatusForbidden},
+		{test_token, `{"filename": "notes.txt", "size": 10, "content_type": "text/plain", "expires_in": "soon"}`, http.StatusBadRequest},
+	} {
+		response := signRequest(t, server, test.token, test.body)
+		if response.StatusCode != test.status {
+			t.Errorf("%s: Expected status %d, got %d", test.body, test.status, response.StatusCode)
+		}
+		var message map[string]string
+		if ok := json.NewDecoder(response.Body).Decode(&message); ok != nil || message["error"] == "" {
+			t.Errorf("%s: Expected an error message: %v", test.body, message)
+		}
+		if test.status == http.StatusUnauthorized && response.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("A 401 should name the Bearer scheme")
+		}
+	}
+
+	response, _ := server.Client().Get(server.URL + "/sign")
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed || response.Header.Get("Allow") != "POST" {
+		t.Errorf("GET /sign should not be allowed: %d", response.StatusCode)
+	}
+}
+
+type failingCredentials struct{}
+
+func (failingCredentials) Retrieve() (credentials.Value, error) {
+	return credentials.Value{}, io.ErrUnexpectedEOF
+}
+
+func TestDegenerateSignFailure(t *testing.T) {
+	var logged bytes.Buffer
+	server := httptest.NewServer(New("johnsmith", failingCredentials{}, WithCaller(test_token, test_rule), WithErrorLog(log.New(&logged, "", 0))))
+	defer server.Close()
+	response := signRequest(t, server, test_token, `{"filename": "notes.txt", "size": 10, "content_type": "text/plain"}`)
+	checkStatus(t, response, http.StatusInternalServerError)
+	body, _ := io.ReadAll(response.Body)
+	if strings.Contains(string(body), "EOF") || !strings.Contains(logged.String(), "unexpected EOF") {
+		t.Errorf("Signing failures should be logged, not returned: %s / %s", body, logged.String())
+	}
+}
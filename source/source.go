@@ -0,0 +1,228 @@
+/*
+Package source resolves the location given to --policy into a policy
+document.  A location is a file path, an http:// or https:// URL, - for
+standard input or a data: URI.
+*/
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+const (
+	// DefaultTimeout bounds a remote fetch, including redirects.
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxSize bounds the size of a policy document.
+	DefaultMaxSize = 1 << 20
+	// maxRedirects matches the limit of http.Client.
+	maxRedirects = 10
+)
+
+/*
+FetchError reports a policy that could not be read.  StatusCode is set when
+a remote server answered with something other than 200.
+*/
+type FetchError struct {
+	Location   string
+	StatusCode int
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("Unable to fetch policy %s: %d %s", e.Location, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("Unable to read policy %s: %s", e.Location, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+/*
+ErrTooLarge is returned for a document bigger than the resolver's MaxSize.
+*/
+var ErrTooLarge = errors.New("Policy document exceeds the maximum size.")
+
+/*
+Resolver reads policy documents.  The zero value uses http.DefaultTransport,
+DefaultTimeout, DefaultMaxSize and os.Stdin.
+*/
+type Resolver struct {
+	Client  *http.Client
+	Timeout time.Duration
+	MaxSize int64
+	Stdin   io.Reader
+}
+
+/*
+Resolve reads and parses the policy at location.
+*/
+func (r *Resolver) Resolve(ctx context.Context, location string) (p *policy.Policy, ok error) {
+	raw, ok := r.Fetch(ctx, location)
+	if ok != nil {
+		return nil, ok
+	}
+	return policy.ParsePolicy(raw)
+}
+
+/*
+Fetch reads the raw bytes of the policy at location.  The bytes are
+returned unmodified so that a signature over them remains valid.
+*/
+func (r *Resolver) Fetch(ctx context.Context, location string) (raw []byte, ok error) {
+	switch {
+	case location == "-":
+		raw, ok = r.readAll(r.stdin())
+	case strings.HasPrefix(location, "data:"):
+		if raw, ok = decodeDataURI(location); ok == nil && int64(len(raw)) > r.maxSize() {
+			raw, ok = nil, ErrTooLarge
+		}
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return r.fetchHTTP(ctx, location)
+	case strings.HasPrefix(location, "file://"):
+		var u *url.URL
+		if u, ok = url.Parse(location); ok == nil {
+			raw, ok = r.readFile(u.Path)
+		}
+	default:
+		raw, ok = r.readFile(location)
+	}
+	if ok != nil {
+		return nil, &FetchError{Location: location, Err: ok}
+	}
+	return raw, nil
+}
+
+func (r *Resolver) stdin() io.Reader {
+	if r.Stdin != nil {
+		return r.Stdin
+	}
+	return os.Stdin
+}
+
+func (r *Resolver) maxSize() int64 {
+	if r.MaxSize > 0 {
+		return r.MaxSize
+	}
+	return DefaultMaxSize
+}
+
+func (r *Resolver) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return DefaultTimeout
+}
+
+/*
+readAll reads at most MaxSize bytes, failing rather than truncating a larger
+document.
+*/
+func (r *Resolver) readAll(reader io.Reader) (raw []byte, ok error) {
+	raw, ok = io.ReadAll(io.LimitReader(reader, r.maxSize()+1))
+	if ok != nil {
+		return nil, ok
+	}
+	if int64(len(raw)) > r.maxSize() {
+		return nil, ErrTooLarge
+	}
+	return raw, nil
+}
+
+func (r *Resolver) readFile(filename string) (raw []byte, ok error) {
+	file, ok := os.Open(filename)
+	if ok != nil {
+		return nil, ok
+	}
+	defer file.Close()
+	return r.readAll(file)
+}
+
+func (r *Resolver) client() *http.Client {
+	client := http.Client{}
+	if r.Client != nil {
+		client = *r.Client
+	}
+	if client.CheckRedirect == nil {
+		client.CheckRedirect = checkRedirect
+	}
+	return &client
+}
+
+/*
+checkRedirect follows up to maxRedirects redirects but never from https to
+http, which would expose the policy to tampering.
+*/
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if via[0].URL.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing to follow a redirect from https to %s", req.URL)
+	}
+	return nil
+}
+
+func (r *Resolver) fetchHTTP(ctx context.Context, location string) (raw []byte, ok error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout())
+	defer cancel()
+
+	request, ok := http.NewRequestWithContext(ctx, "GET", location, nil)
+	if ok != nil {
+		return nil, &FetchError{Location: location, Err: ok}
+	}
+	response, ok := r.client().Do(request)
+	if ok != nil {
+		return nil, &FetchError{Location: location, Err: ok}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, &FetchError{Location: location, StatusCode: response.StatusCode}
+	}
+	if raw, ok = r.readAll(response.Body); ok != nil {
+		return nil, &FetchError{Location: location, Err: ok}
+	}
+	return raw, nil
+}
+
+/*
+decodeDataURI decodes data:[<mediatype>][;base64],<data>.
+
+https://tools.ietf.org/html/rfc2397
+*/
+func decodeDataURI(location string) (raw []byte, ok error) {
+	meta, data, found := strings.Cut(strings.TrimPrefix(location, "data:"), ",")
+	if !found {
+		return nil, errors.New("Invalid data URI.  Missing ','.")
+	}
+	if strings.HasSuffix(meta, ";base64") {
+		data = strings.Map(func(r rune) rune {
+			if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+				return -1
+			}
+			return r
+		}, data)
+		if raw, ok = base64.StdEncoding.DecodeString(data); ok != nil {
+			raw, ok = base64.URLEncoding.DecodeString(data)
+		}
+		return
+	}
+	unescaped, ok := url.PathUnescape(data)
+	if ok != nil {
+		return nil, ok
+	}
+	return []byte(unescaped), nil
+}
@@ -0,0 +1,125 @@
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const example_policy = `{ "expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"]
+  ]
+}
+`
+
+func checkFetch(t *testing.T, r *Resolver, location string) {
+	raw, ok := r.Fetch(context.Background(), location)
+	if ok != nil {
+		t.Fatalf("Unable to fetch %s: %s", location, ok)
+	}
+	if string(raw) != example_policy {
+		t.Errorf("Policy bytes should be returned unmodified, got: %q", raw)
+	}
+	if _, ok := r.Resolve(context.Background(), location); ok != nil {
+		t.Errorf("Unable to resolve %s: %s", location, ok)
+	}
+}
+
+func checkFetchError(t *testing.T, ok error, statusCode int) *FetchError {
+	var fetchErr *FetchError
+	if !errors.As(ok, &fetchErr) {
+		t.Fatalf("Expected a *FetchError, got: %v", ok)
+	}
+	if fetchErr.StatusCode != statusCode {
+		t.Errorf("Expected status %d, got %d", statusCode, fetchErr.StatusCode)
+	}
+	return fetchErr
+}
+
+func TestFetchFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "upload.policy")
+	os.WriteFile(filename, []byte(example_policy), 0644)
+	checkFetch(t, &Resolver{}, filename)
+	checkFetch(t, &Resolver{}, (&url.URL{Scheme: "file", Path: filename}).String())
+}
+
+func TestFetchStdin(t *testing.T) {
+	raw, ok := (&Resolver{Stdin: strings.NewReader(example_policy)}).Fetch(context.Background(), "-")
+	if ok != nil || string(raw) != example_policy {
+		t.Errorf("Unable to read the policy from stdin: %q %v", raw, ok)
+	}
+}
+
+func TestFetchDataURI(t *testing.T) {
+	checkFetch(t, &Resolver{}, "data:application/json;base64,"+base64.StdEncoding.EncodeToString([]byte(example_policy)))
+	checkFetch(t, &Resolver{}, "data:application/json,"+url.PathEscape(example_policy))
+}
+
+func TestFetchHTTPFollowsRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old.policy", http.RedirectHandler("/remote.policy", http.StatusFound))
+	mux.HandleFunc("/remote.policy", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(example_policy))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	checkFetch(t, &Resolver{}, server.URL+"/old.policy")
+}
+
+func TestDegenerateFetchHTTPStatus(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	_, ok := (&Resolver{}).Fetch(context.Background(), server.URL+"/missing.policy")
+	checkFetchError(t, ok, http.StatusNotFound)
+}
+
+func TestDegenerateFetchHTTPTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	_, ok := (&Resolver{Timeout: 10 * time.Millisecond}).Fetch(context.Background(), server.URL)
+	if !errors.Is(checkFetchError(t, ok, 0), context.DeadlineExceeded) {
+		t.Errorf("Expected the fetch to time out, got: %s", ok)
+	}
+}
+
+func TestDegenerateFetchTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(example_policy))
+	}))
+	defer server.Close()
+
+	r := &Resolver{MaxSize: 16}
+	for _, location := range []string{server.URL, "data:," + url.PathEscape(example_policy)} {
+		if _, ok := r.Fetch(context.Background(), location); !errors.Is(ok, ErrTooLarge) {
+			t.Errorf("Expected ErrTooLarge for %s, got: %v", location, ok)
+		}
+	}
+}
+
+func TestDegenerateFetchMissingFile(t *testing.T) {
+	_, ok := (&Resolver{}).Fetch(context.Background(), filepath.Join(t.TempDir(), "missing.policy"))
+	if !errors.Is(checkFetchError(t, ok, 0), os.ErrNotExist) {
+		t.Errorf("Expected a missing file error, got: %s", ok)
+	}
+}
+
+func TestDegenerateInvalidDataURI(t *testing.T) {
+	if _, ok := (&Resolver{}).Fetch(context.Background(), "data:application/json;base64"); ok == nil {
+		t.Errorf("A data URI without data should be an error")
+	}
+}
@@ -0,0 +1,226 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+/*
+DefaultWorkers is the number of files a Batch uploads at once unless
+Workers is set.
+*/
+const DefaultWorkers = 4
+
+/*
+BatchFile is a file on disk and the name it is uploaded as.  The name is
+resolved against the $key prefix of the policy, so dir/a/b.txt walked from
+dir is uploaded as <prefix>a/b.txt.
+*/
+type BatchFile struct {
+	Path string
+	Name string
+	Size int64
+}
+
+/*
+BatchResult is the outcome of uploading a single BatchFile.  Exactly one of
+Result and Err is set.
+*/
+type BatchResult struct {
+	File   BatchFile
+	Result *UploadResult
+	Err    error
+}
+
+/*
+Batch uploads many files with the same policy through a bounded pool of
+workers.  A failed file does not stop the rest of the batch.
+*/
+type Batch struct {
+	// Policy is the policy document every file is uploaded with.
+	Policy []byte
+
+	// Workers bounds the number of concurrent uploads, DefaultWorkers when zero.
+	Workers int
+
+	// Options are passed to NewSingleFileUploader for every file.
+	Options []Option
+
+	// Progress, when set, receives the progress of each file.
+	Progress func(file BatchFile, sent, total int64)
+
+	// Done, when set, is called as each file finishes, one call at a time.
+	Done func(result BatchResult)
+}
+
+/*
+WalkFiles expands paths into the files to upload.  A file is uploaded under
+its base name; the files below a directory are uploaded under their path
+relative to it.  Two files that would be uploaded under the same name are an
+error.
+*/
+func WalkFiles(paths []string) (files []BatchFile, ok error) {
+	seen := map[string]string{}
+	add := func(path, name string, info fs.FileInfo) error {
+		if previous, found := seen[name]; found {
+			return fmt.Errorf("%s and %s would both be uploaded as %s.", previous, path, name)
+		}
+		seen[name] = path
+		files = append(files, BatchFile{Path: path, Name: name, Size: info.Size()})
+		return nil
+	}
+
+	for _, root := range paths {
+		info, ok := os.Stat(root)
+		if ok != nil {
+			return nil, ok
+		}
+		if !info.IsDir() {
+			if ok = add(root, filepath.Base(root), info); ok != nil {
+				return nil, ok
+			}
+			continue
+		}
+		ok = filepath.WalkDir(root, func(path string, entry fs.DirEntry, ok error) error {
+			if ok != nil || entry.IsDir() {
+				return ok
+			}
+			info, ok := os.Stat(path)
+			if ok != nil {
+				return ok
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, ok := filepath.Rel(root, path)
+			if ok != nil {
+				return ok
+			}
+			return add(path, filepath.ToSlash(rel), info)
+		})
+		if ok != nil {
+			return nil, ok
+		}
+	}
+	return files, nil
+}
+
+/*
+Check reports whether files can be uploaded with the batch's policy.  A
+policy whose $key condition is an exact match can only upload a single file:
+every other file would overwrite it.  Several files need a starts-with
+condition on $key, a presigned form needs ${filename} in its key and a key
+template needs {filename} or {uuid}.
+*/
+func (b *Batch) Check(files []BatchFile) error {
+	if len(files) <= 1 {
+		return nil
+	}
+	p, ok := policy.ParsePolicy(b.Policy)
+	if ok != nil {
+		return ok
+	}
+	condition, _ := p.Condition("$key")
+	if _, prefix := condition.(policy.ConditionStartsWith); !prefix {
+		return fmt.Errorf("The policy fixes the key to %q, so it can only upload one file, not %d.  Use a policy with a starts-with condition on $key.", condition.ValueString(), len(files))
+	}
+
+	var options Options
+	for _, option := range b.Options {
+		option(&options)
+	}
+	for _, field := range options.presigned {
+		if strings.EqualFold(field.Name, "key") && !strings.Contains(field.Value, "${filename}") {
+			return fmt.Errorf("The key %q is the same for every file, so it can only upload one file, not %d.  Use a key with ${filename} in it.", field.Value, len(files))
+		}
+	}
+	perFile := strings.ReplaceAll(options.keyTemplate, "${filename}", "{filename}")
+	if perFile != "" && !strings.Contains(perFile, "{filename}") && !strings.Contains(perFile, "{uuid}") {
+		return fmt.Errorf("The key template %q is the same for every file, so it can only upload one file, not %d.  Use a template with {filename} in it.", options.keyTemplate, len(files))
+	}
+	return nil
+}
+
+/*
+Upload sends every file and returns their results in the order of files.
+When Check rejects the batch, no file is sent and every result carries its
+error.
+*/
+func (b *Batch) Upload(ctx context.Context, files []BatchFile) (results []BatchResult) {
+	results = make([]BatchResult, len(files))
+	if ok := b.Check(files); ok != nil {
+		for i, file := range files {
+			results[i] = BatchResult{File: file, Err: ok}
+			if b.Done != nil {
+				b.Done(results[i])
+			}
+		}
+		return results
+	}
+	workers := b.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	next := make(chan int)
+	var (
+		wg   sync.WaitGroup
+		done sync.Mutex
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range next {
+				file := files[index]
+				result, ok := b.uploadFile(ctx, file)
+				results[index] = BatchResult{File: file, Result: result, Err: ok}
+				if b.Done != nil {
+					done.Lock()
+					b.Done(results[index])
+					done.Unlock()
+				}
+			}
+		}()
+	}
+	for index := range files {
+		next <- index
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+func (b *Batch) uploadFile(ctx context.Context, file BatchFile) (result *UploadResult, ok error) {
+	if ok = ctx.Err(); ok != nil {
+		return nil, ok
+	}
+	f, ok := os.Open(file.Path)
+	if ok != nil {
+		return nil, ok
+	}
+	defer f.Close()
+
+	opts := b.Options
+	if b.Progress != nil {
+		opts = append(opts[:len(opts):len(opts)], WithProgress(func(sent, total int64) {
+			b.Progress(file, sent, total)
+		}))
+	}
+	uploader, ok := NewSingleFileUploader(bytes.NewReader(b.Policy), file.Name, f, opts...)
+	if ok != nil {
+		return nil, ok
+	}
+	return uploader.Upload(ctx)
+}
@@ -0,0 +1,189 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, root string, names ...string) {
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if ok := os.WriteFile(path, []byte(name), 0644); ok != nil {
+			t.Fatalf("Unable to write %s: %s", name, ok)
+		}
+	}
+}
+
+func batchNames(files []BatchFile) (names []string) {
+	for _, file := range files {
+		names = append(names, file.Name)
+	}
+	return
+}
+
+func TestWalkFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "dir/a.txt", "dir/sub/b.txt", "dir/sub/deeper/c.txt", "single.txt")
+
+	files, ok := WalkFiles([]string{filepath.Join(root, "dir"), filepath.Join(root, "single.txt")})
+	if ok != nil {
+		t.Fatalf("Unable to walk files: %s", ok)
+	}
+	expected := []string{"a.txt", "sub/b.txt", "sub/deeper/c.txt", "single.txt"}
+	if names := batchNames(files); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Unexpected names.\nExpected: %v\nActual: %v", expected, names)
+	}
+	if files[1].Size != int64(len("dir/sub/b.txt")) {
+		t.Errorf("Unexpected size: %d", files[1].Size)
+	}
+}
+
+func TestDegenerateWalkFilesSameName(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "dir/a.txt", "a.txt")
+	if _, ok := WalkFiles([]string{filepath.Join(root, "dir"), filepath.Join(root, "a.txt")}); ok == nil {
+		t.Errorf("Two files uploaded as a.txt should be an error")
+	}
+}
+
+func TestDegenerateWalkFilesMissing(t *testing.T) {
+	if _, ok := WalkFiles([]string{filepath.Join(t.TempDir(), "missing")}); ok == nil {
+		t.Errorf("A missing path should be an error")
+	}
+}
+
+func TestBatchUpload(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "a.txt", "bad.txt", "sub/c.txt", "sub/d.txt", "e.txt")
+	files, _ := WalkFiles([]string{root})
+
+	var (
+		mu              sync.Mutex
+		active, maxSeen int
+		keys            []string
+	)
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxSeen {
+			maxSeen = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+
+		_, values := readFormFields(t, r)
+		mu.Lock()
+		active--
+		keys = append(keys, values["key"])
+		mu.Unlock()
+		if strings.Contains(values["key"], "bad") {
+			accessDenied(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	var done []string
+	batch := &Batch{
+		Policy:  []byte(UPLOAD_POLICY_EXAMPLE),
+		Workers: 2,
+		Options: []Option{WithHTTPClient(client), testCredentials, testContentType},
+		Done: func(result BatchResult) {
+			done = append(done, result.File.Name)
+		},
+	}
+	results := batch.Upload(context.Background(), files)
+
+	if len(results) != len(files) || len(done) != len(files) {
+		t.Fatalf("Expected a result for each of %d files, got %d results and %d done", len(files), len(results), len(done))
+	}
+	for i, result := range results {
+		if result.File != files[i] {
+			t.Errorf("Results should be in the order of files: %d is %s", i, result.File.Name)
+		}
+		failed := result.File.Name == "bad.txt"
+		if failed != (result.Err != nil) {
+			t.Errorf("Unexpected outcome for %s: %v", result.File.Name, result.Err)
+		}
+		if !failed && result.Result.Key != "user/eric/"+result.File.Name {
+			t.Errorf("Unexpected key for %s: %s", result.File.Name, result.Result.Key)
+		}
+	}
+	if maxSeen > 2 {
+		t.Errorf("At most 2 uploads should run at once, saw %d", maxSeen)
+	}
+	if len(keys) != len(files) {
+		t.Errorf("Every file should be sent despite the failure, got %v", keys)
+	}
+}
+
+func TestDegenerateBatchExactKey(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "a.txt", "b.txt")
+	files, _ := WalkFiles([]string{root})
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("No file should be sent when the key is fixed")
+	})
+
+	batch := &Batch{
+		Policy:  []byte(`{"expiration": "2007-12-01T12:00:00.000Z", "conditions": [{"bucket": "johnsmith"}, ["eq", "$key", "user/eric/fixed.txt"]]}`),
+		Options: []Option{WithHTTPClient(client), testCredentials},
+	}
+	if ok := batch.Check(files); ok == nil || !strings.Contains(ok.Error(), "only upload one file") {
+		t.Errorf("Expected several files with a fixed key to be rejected, got %v", ok)
+	}
+	for _, result := range batch.Upload(context.Background(), files) {
+		if result.Err == nil {
+			t.Errorf("Expected %s to fail", result.File.Name)
+		}
+	}
+	if ok := batch.Check(files[:1]); ok != nil {
+		t.Errorf("A single file may be uploaded to a fixed key: %s", ok)
+	}
+}
+
+func TestDegenerateBatchPresignedKeyWithoutFilename(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "a.txt", "b.txt")
+	files, _ := WalkFiles([]string{root})
+
+	form := func(key string) Option {
+		return WithPresignedForm("https://johnsmith.s3.amazonaws.com/", []FormField{{Name: "key", Value: key}})
+	}
+	batch := &Batch{Policy: []byte(UPLOAD_POLICY_EXAMPLE), Options: []Option{form("user/eric/upload.txt")}}
+	if ok := batch.Check(files); ok == nil || !strings.Contains(ok.Error(), "same for every file") {
+		t.Errorf("Expected a key without ${filename} to be rejected, got %v", ok)
+	}
+	if ok := batch.Check(files[:1]); ok != nil {
+		t.Errorf("A single file may be uploaded to a fixed key: %s", ok)
+	}
+	batch.Options = []Option{form("user/eric/${filename}")}
+	if ok := batch.Check(files); ok != nil {
+		t.Errorf("${filename} gives each file its own key: %s", ok)
+	}
+}
+
+func TestDegenerateBatchKeyTemplateWithoutFilename(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "a.txt", "b.txt")
+	files, _ := WalkFiles([]string{root})
+
+	batch := &Batch{Policy: []byte(UPLOAD_POLICY_EXAMPLE), Options: []Option{WithKeyTemplate("{prefix}{date}.txt")}}
+	if ok := batch.Check(files); ok == nil || !strings.Contains(ok.Error(), "same for every file") {
+		t.Errorf("Expected a key template without {filename} to be rejected, got %v", ok)
+	}
+	for _, template := range []string{"{prefix}{date}/{filename}", "{prefix}${filename}", "{prefix}{uuid}"} {
+		batch.Options = []Option{WithKeyTemplate(template)}
+		if ok := batch.Check(files); ok != nil {
+			t.Errorf("%s gives each file its own key: %s", template, ok)
+		}
+	}
+}
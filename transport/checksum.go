@@ -0,0 +1,205 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+// An ETag that is the MD5 of the object.  Multipart uploads add -<parts>.
+var md5ETag = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+/*
+Checksums are the hex encoded digests of the file as it was sent.
+*/
+type Checksums struct {
+	MD5    string
+	SHA256 string
+	CRC32C string
+}
+
+/*
+IntegrityError reports that S3 describes the stored object with a different
+checksum than the file that was sent.
+*/
+type IntegrityError struct {
+	Bucket    string
+	Key       string
+	Algorithm string
+	Local     string
+	Remote    string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("The %s of s3://%s/%s returned by S3, %s, does not match the file sent, %s.",
+		e.Algorithm, e.Bucket, e.Key, e.Remote, e.Local)
+}
+
+/*
+digestFile writes the rest of file to hashes and returns a reader over the
+same bytes: file itself, seeked back, or a copy in memory when it can not
+seek.
+*/
+func digestFile(file io.Reader, hashes ...hash.Hash) (rest io.Reader, ok error) {
+	writers := make([]io.Writer, len(hashes))
+	for i, h := range hashes {
+		writers[i] = h
+	}
+	out := io.MultiWriter(writers...)
+
+	if seeker, isSeeker := file.(io.Seeker); isSeeker {
+		offset, ok := seeker.Seek(0, io.SeekCurrent)
+		if ok != nil {
+			return nil, ok
+		}
+		if _, ok = io.Copy(out, file); ok != nil {
+			return nil, ok
+		}
+		if _, ok = seeker.Seek(offset, io.SeekStart); ok != nil {
+			return nil, ok
+		}
+		return file, nil
+	}
+
+	contents, ok := io.ReadAll(file)
+	if ok != nil {
+		return nil, ok
+	}
+	out.Write(contents)
+	return bytes.NewReader(contents), nil
+}
+
+/*
+checksumFields fills in the Content-MD5, x-amz-checksum-sha256,
+x-amz-checksum-crc32c and x-amz-checksum-algorithm fields when the policy
+has a condition on them, so S3 verifies the file it receives.  The form
+fields precede the file, so this reads the file an extra time.
+*/
+func checksumFields(p *policy.Policy, fields []FormField, file io.Reader) (withChecksums []FormField, rest io.Reader, ok error) {
+	wanted := map[string]string{}
+	for _, condition := range p.Conditions {
+		switch name := policy.FieldName(condition.Name()); name {
+		case "content-md5", "x-amz-checksum-sha256", "x-amz-checksum-crc32c", "x-amz-checksum-algorithm":
+			wanted[name] = formFieldName(condition)
+		}
+	}
+	if len(wanted) == 0 {
+		return fields, file, nil
+	}
+
+	md5Hash, sha256Hash, crc32cHash := md5.New(), sha256.New(), crc32.New(castagnoli)
+	if rest, ok = digestFile(file, md5Hash, sha256Hash, crc32cHash); ok != nil {
+		return nil, nil, ok
+	}
+	values := map[string]string{
+		"content-md5":           base64.StdEncoding.EncodeToString(md5Hash.Sum(nil)),
+		"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(sha256Hash.Sum(nil)),
+		"x-amz-checksum-crc32c": base64.StdEncoding.EncodeToString(crc32cHash.Sum(nil)),
+	}
+	if _, found := wanted["x-amz-checksum-sha256"]; found {
+		values["x-amz-checksum-algorithm"] = "SHA256"
+	} else if _, found := wanted["x-amz-checksum-crc32c"]; found {
+		values["x-amz-checksum-algorithm"] = "CRC32C"
+	}
+
+	for field, name := range wanted {
+		if value, found := values[field]; found {
+			fields = setFormField(fields, name, value)
+		}
+	}
+	return fields, rest, nil
+}
+
+/*
+checksumReader hashes the file as it is streamed and records the digests
+once all of it has been read.
+*/
+type checksumReader struct {
+	r      io.Reader
+	md5    hash.Hash
+	sha256 hash.Hash
+	crc32c hash.Hash
+	done   func(sums Checksums)
+}
+
+func newChecksumReader(r io.Reader, done func(sums Checksums)) *checksumReader {
+	return &checksumReader{r: r, md5: md5.New(), sha256: sha256.New(), crc32c: crc32.New(castagnoli), done: done}
+}
+
+func (c *checksumReader) Read(p []byte) (n int, ok error) {
+	n, ok = c.r.Read(p)
+	c.md5.Write(p[:n])
+	c.sha256.Write(p[:n])
+	c.crc32c.Write(p[:n])
+	if ok == io.EOF && c.done != nil {
+		c.done(Checksums{
+			MD5:    hex.EncodeToString(c.md5.Sum(nil)),
+			SHA256: hex.EncodeToString(c.sha256.Sum(nil)),
+			CRC32C: hex.EncodeToString(c.crc32c.Sum(nil)),
+		})
+		c.done = nil
+	}
+	return
+}
+
+/*
+sentChecksums holds the digests of the last complete read of the file.
+*/
+type sentChecksums struct {
+	mu   sync.Mutex
+	sums *Checksums
+}
+
+func (s *sentChecksums) set(sums Checksums) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sums = &sums
+}
+
+func (s *sentChecksums) get() (sums Checksums, complete bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sums == nil {
+		return Checksums{}, false
+	}
+	return *s.sums, true
+}
+
+/*
+verifyChecksums compares the file sent with the ETag, when it is the MD5 of
+the object, and the SHA-256 and CRC32C checksums S3 returns.  The ETag of an
+object encrypted with KMS is not its MD5 and is not compared.
+*/
+func verifyChecksums(result *UploadResult, response *http.Response) error {
+	sums := result.Checksums
+	kms := strings.HasPrefix(response.Header.Get("x-amz-server-side-encryption"), "aws:kms")
+	if sums.MD5 != "" && !kms && md5ETag.MatchString(result.ETag) && !strings.EqualFold(result.ETag, sums.MD5) {
+		return &IntegrityError{result.Bucket, result.Key, "MD5", sums.MD5, result.ETag}
+	}
+	for _, checksum := range []struct{ header, algorithm, sum string }{
+		{"x-amz-checksum-sha256", "SHA-256", sums.SHA256},
+		{"x-amz-checksum-crc32c", "CRC32C", sums.CRC32C},
+	} {
+		if remote := response.Header.Get(checksum.header); remote != "" && checksum.sum != "" {
+			digest, _ := hex.DecodeString(checksum.sum)
+			if local := base64.StdEncoding.EncodeToString(digest); local != remote {
+				return &IntegrityError{result.Bucket, result.Key, checksum.algorithm, local, remote}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+)
+
+const checksum_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    ["starts-with", "$Content-MD5", ""],
+    ["starts-with", "$x-amz-checksum-sha256", ""],
+    ["starts-with", "$x-amz-checksum-algorithm", ""]
+  ]
+}`
+
+var file_contents_checksums = Checksums{
+	MD5:    "4a8ec4fa5f01b4ab1a0ab8cbccb709f0",
+	SHA256: "7bb6f9f7a47a63e684925af3608c059edcc371eb81188c48c9714896fb1091fd",
+	CRC32C: "71671363",
+}
+
+func TestUploadChecksumFields(t *testing.T) {
+	server := newS3TestServer(t)
+	result, ok := uploadTo(t, server, checksum_policy)
+	if ok != nil {
+		t.Fatalf("Upload failed: %s", ok)
+	}
+	checkStored(t, server, result)
+	if result.Checksums != file_contents_checksums {
+		t.Errorf("Unexpected checksums: %+v", result.Checksums)
+	}
+
+	object, _ := server.Object("johnsmith", "user/eric/file1.ext")
+	for name, expected := range map[string]string{
+		"content-md5":              "So7E+l8BtKsaCrjLzLcJ8A==",
+		"x-amz-checksum-sha256":    "e7b596R6Y+aEklrzYIwFntzDceuBGIxIyXFIlvsQkf0=",
+		"x-amz-checksum-algorithm": "SHA256",
+	} {
+		if object.Fields[name] != expected {
+			t.Errorf("Expected %s to be %s, got %q", name, expected, object.Fields[name])
+		}
+	}
+}
+
+func TestUploadChecksumsWithoutPolicyFields(t *testing.T) {
+	server := newS3TestServer(t)
+	result, ok := uploadTo(t, server, s3test_policy)
+	if ok != nil {
+		t.Fatalf("Upload failed: %s", ok)
+	}
+	if result.Checksums != file_contents_checksums {
+		t.Errorf("Unexpected checksums: %+v", result.Checksums)
+	}
+	object, _ := server.Object("johnsmith", "user/eric/file1.ext")
+	if _, found := object.Fields["content-md5"]; found {
+		t.Errorf("Content-MD5 should only be sent when the policy allows it")
+	}
+}
+
+func TestDegenerateUploadETagMismatch(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"9a0364b9e99bb480dd25e1f0284c8555"`)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	_, ok := newTestUploader(t, client).Upload(context.Background())
+	var integrity *IntegrityError
+	if !errors.As(ok, &integrity) {
+		t.Fatalf("Expected an *IntegrityError, got: %#v", ok)
+	}
+	expected := IntegrityError{
+		Bucket:    "johnsmith",
+		Key:       "user/eric/file1.ext",
+		Algorithm: "MD5",
+		Local:     "4a8ec4fa5f01b4ab1a0ab8cbccb709f0",
+		Remote:    "9a0364b9e99bb480dd25e1f0284c8555",
+	}
+	if *integrity != expected {
+		t.Errorf("Unexpected error.  Expected:\n%+v\nActual:\n%+v", expected, *integrity)
+	}
+}
+
+func TestDegenerateUploadChecksumMismatch(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("x-amz-checksum-sha256", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	_, ok := newTestUploader(t, client).Upload(context.Background())
+	var integrity *IntegrityError
+	if !errors.As(ok, &integrity) || integrity.Algorithm != "SHA-256" {
+		t.Errorf("Expected a SHA-256 *IntegrityError, got: %v", ok)
+	}
+}
+
+func TestDegenerateUploadCRC32CMismatch(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("x-amz-checksum-crc32c", "AAAAAA==")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	_, ok := newTestUploader(t, client).Upload(context.Background())
+	var integrity *IntegrityError
+	if !errors.As(ok, &integrity) || integrity.Algorithm != "CRC32C" || integrity.Local != "cWcTYw==" {
+		t.Errorf("Expected a CRC32C *IntegrityError, got: %v", ok)
+	}
+}
+
+func TestUploadCRC32CField(t *testing.T) {
+	server := newS3TestServer(t)
+	result, ok := uploadTo(t, server, `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    ["starts-with", "$x-amz-checksum-crc32c", ""],
+    ["starts-with", "$x-amz-checksum-algorithm", ""]
+  ]
+}`)
+	if ok != nil {
+		t.Fatalf("Upload failed: %s", ok)
+	}
+	object, _ := server.Object("johnsmith", "user/eric/file1.ext")
+	if object.Fields["x-amz-checksum-crc32c"] != "cWcTYw==" || object.Fields["x-amz-checksum-algorithm"] != "CRC32C" {
+		t.Errorf("Unexpected checksum fields: %v", object.Fields)
+	}
+	if result.Checksums.CRC32C != file_contents_checksums.CRC32C {
+		t.Errorf("Unexpected checksums: %+v", result.Checksums)
+	}
+}
+
+func TestUploadKMSETagNotCompared(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"9a0364b9e99bb480dd25e1f0284c8555"`)
+		w.Header().Set("x-amz-server-side-encryption", "aws:kms")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if _, ok := newTestUploader(t, client).Upload(context.Background()); ok != nil {
+		t.Errorf("The ETag of a KMS encrypted object is not its MD5: %s", ok)
+	}
+}
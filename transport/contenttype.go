@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+// http.DetectContentType considers at most the first 512 bytes.
+const sniffLength = 512
+
+/*
+WithContentType sends contentType as the type of every file instead of
+detecting it.  It must still satisfy the policy's Content-Type condition.
+*/
+func WithContentType(contentType string) Option {
+	return func(o *Options) {
+		o.contentType = contentType
+	}
+}
+
+/*
+DetectContentType determines the type of a file from the extension of
+filename or, when the extension is unknown, by sniffing the start of the
+file.  The returned reader yields the whole file.
+*/
+func DetectContentType(filename string, file io.Reader) (contentType string, rest io.Reader, ok error) {
+	if contentType = mime.TypeByExtension(path.Ext(filename)); contentType != "" {
+		return contentType, file, nil
+	}
+
+	head := make([]byte, sniffLength)
+	if seeker, isSeeker := file.(io.Seeker); isSeeker {
+		offset, ok := seeker.Seek(0, io.SeekCurrent)
+		if ok != nil {
+			return "", nil, ok
+		}
+		n, ok := io.ReadFull(file, head)
+		if ok != nil && ok != io.EOF && ok != io.ErrUnexpectedEOF {
+			return "", nil, ok
+		}
+		if _, ok = seeker.Seek(offset, io.SeekStart); ok != nil {
+			return "", nil, ok
+		}
+		return http.DetectContentType(head[:n]), file, nil
+	}
+
+	n, ok := io.ReadFull(file, head)
+	if ok != nil && ok != io.EOF && ok != io.ErrUnexpectedEOF {
+		return "", nil, ok
+	}
+	head = head[:n]
+	return http.DetectContentType(head), io.MultiReader(bytes.NewReader(head), file), nil
+}
+
+/*
+contentTypeCondition finds the policy's condition on Content-Type.
+*/
+func contentTypeCondition(p *policy.Policy) (condition policy.Condition, found bool) {
+	for _, condition := range p.Conditions {
+		if policy.FieldName(condition.Name()) == "content-type" {
+			return condition, true
+		}
+	}
+	return nil, false
+}
+
+/*
+fileContentType chooses the type the file is sent with and checks it
+against the policy.  S3 rejects form fields the policy does not mention, so
+the Content-Type field is only sent when the policy has a condition on it;
+the type is always given in the header of the file part.
+*/
+func (o *Options) fileContentType(p *policy.Policy, filename string, file io.Reader, fields []FormField) (contentType string, rest io.Reader, withField []FormField, ok error) {
+	contentType, rest = o.contentType, file
+	if contentType == "" {
+		if contentType, rest, ok = DetectContentType(filename, file); ok != nil {
+			return "", nil, nil, ok
+		}
+	}
+
+	condition, found := contentTypeCondition(p)
+	if !found {
+		return contentType, rest, fields, nil
+	}
+	if !condition.Matches(condition.Name(), contentType) {
+		return "", nil, nil, fmt.Errorf("The content type %q of %s does not satisfy the policy's condition on Content-Type: %s.",
+			contentType, filename, condition.ValueString())
+	}
+	return contentType, rest, setFormField(fields, formFieldName(condition), contentType), nil
+}
@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Bucket names that can be used as a host name.
+	dnsBucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	// Buckets created in us-east-1 before March 2018 may also use upper
+	// case letters and underscores, but only with path-style addressing.
+	legacyBucketName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,255}$`)
+)
+
+/*
+WithEndpoint sends uploads to an S3 compatible store, such as MinIO, Ceph or
+LocalStack, at endpoint, e.g. http://localhost:9000.  Buckets are addressed
+by path: http://localhost:9000/<bucket>/.
+*/
+func WithEndpoint(endpoint string) Option {
+	return func(o *Options) {
+		o.endpoint = endpoint
+	}
+}
+
+/*
+WithPathStyle addresses the bucket by path, https://s3.<region>.amazonaws.com/<bucket>/,
+instead of by host name.  Buckets with dots in their name are always
+addressed by path since they do not match the certificate of the host.
+*/
+func WithPathStyle() Option {
+	return func(o *Options) {
+		o.pathStyle = true
+	}
+}
+
+/*
+WithDualStack uploads to the endpoint of the region that accepts IPv6 as
+well as IPv4.
+*/
+func WithDualStack() Option {
+	return func(o *Options) {
+		o.dualStack = true
+	}
+}
+
+/*
+WithAccelerate uploads through S3 Transfer Acceleration.  The bucket must
+have acceleration enabled and can not have dots in its name.
+*/
+func WithAccelerate() Option {
+	return func(o *Options) {
+		o.accelerate = true
+	}
+}
+
+/*
+s3Host is the host name of the S3 endpoint for region.
+
+http://docs.aws.amazon.com/general/latest/gr/s3.html
+*/
+func s3Host(region string, dualStack, accelerate bool) string {
+	switch {
+	case accelerate && dualStack:
+		return "s3-accelerate.dualstack.amazonaws.com"
+	case accelerate:
+		return "s3-accelerate.amazonaws.com"
+	}
+	domain := "amazonaws.com"
+	if strings.HasPrefix(region, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	switch {
+	case dualStack:
+		return fmt.Sprintf("s3.dualstack.%s.%s", region, domain)
+	case region == "us-east-1":
+		return "s3.amazonaws.com"
+	}
+	return fmt.Sprintf("s3.%s.%s", region, domain)
+}
+
+/*
+checkBucket verifies the bucket name can be addressed by host name, when
+virtualHosted, or by path.
+
+http://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
+*/
+func checkBucket(bucket string, virtualHosted bool) error {
+	if bucket == "" {
+		return errors.New("Missing bucket.  The policy requires a bucket condition.")
+	}
+	if net.ParseIP(bucket) != nil {
+		return fmt.Errorf("Invalid bucket name %q.  Bucket names can not be IP addresses.", bucket)
+	}
+	if !virtualHosted {
+		if !legacyBucketName.MatchString(bucket) {
+			return fmt.Errorf("Invalid bucket name %q.", bucket)
+		}
+		return nil
+	}
+	if !dnsBucketName.MatchString(bucket) || strings.Contains(bucket, "..") {
+		return fmt.Errorf("The bucket %q can not be addressed by host name.  Use path-style addressing.", bucket)
+	}
+	if strings.Contains(bucket, ".") {
+		return fmt.Errorf("The bucket %q has dots in its name, which do not match the certificate of the endpoint.", bucket)
+	}
+	return nil
+}
+
+/*
+bucketURL is the URL the form is posted to.
+*/
+func (o *Options) bucketURL() (bucketURL *url.URL, ok error) {
+	region := o.region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	if o.endpoint != "" {
+		if o.accelerate || o.dualStack {
+			return nil, errors.New("Acceleration and dual-stack are only available from AWS endpoints.")
+		}
+		if bucketURL, ok = url.Parse(o.endpoint); ok != nil {
+			return nil, ok
+		}
+		if bucketURL.Scheme == "" || bucketURL.Host == "" {
+			return nil, fmt.Errorf("The endpoint %q must be an absolute URL.", o.endpoint)
+		}
+		if ok = checkBucket(o.bucket, false); ok != nil {
+			return nil, ok
+		}
+		bucketURL.Path = strings.TrimSuffix(bucketURL.Path, "/") + "/" + o.bucket + "/"
+		return bucketURL, nil
+	}
+
+	virtualHosted := !o.pathStyle && !strings.Contains(o.bucket, ".")
+	if o.accelerate {
+		if o.pathStyle {
+			return nil, errors.New("Transfer acceleration requires the bucket to be addressed by host name.")
+		}
+		virtualHosted = true
+	}
+	if ok = checkBucket(o.bucket, virtualHosted); ok != nil {
+		return nil, ok
+	}
+
+	host := s3Host(region, o.dualStack, o.accelerate)
+	if virtualHosted {
+		return &url.URL{Scheme: "https", Host: o.bucket + "." + host, Path: "/"}, nil
+	}
+	return &url.URL{Scheme: "https", Host: host, Path: "/" + o.bucket + "/"}, nil
+}
+
+/*
+BucketURL is the URL an upload form for bucket is posted to, as chosen by
+WithRegion, WithEndpoint, WithPathStyle, WithDualStack and WithAccelerate.
+*/
+func BucketURL(bucket string, opts ...Option) (*url.URL, error) {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.bucket = bucket
+	return o.bucketURL()
+}
@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"strings"
+	"testing"
+)
+
+func bucketURLFor(bucket string, opts ...Option) (string, error) {
+	u, ok := BucketURL(bucket, opts...)
+	if ok != nil {
+		return "", ok
+	}
+	return u.String(), nil
+}
+
+func TestBucketURL(t *testing.T) {
+	for _, test := range []struct {
+		bucket   string
+		opts     []Option
+		expected string
+	}{
+		{"johnsmith", nil, "https://johnsmith.s3.amazonaws.com/"},
+		{"johnsmith", []Option{WithRegion("eu-west-1")}, "https://johnsmith.s3.eu-west-1.amazonaws.com/"},
+		{"johnsmith", []Option{WithRegion("cn-north-1")}, "https://johnsmith.s3.cn-north-1.amazonaws.com.cn/"},
+		{"johnsmith", []Option{WithPathStyle()}, "https://s3.amazonaws.com/johnsmith/"},
+		{"johnsmith", []Option{WithRegion("eu-west-1"), WithPathStyle()}, "https://s3.eu-west-1.amazonaws.com/johnsmith/"},
+		{"www.example.com", nil, "https://s3.amazonaws.com/www.example.com/"},
+		{"Legacy_Bucket", []Option{WithPathStyle()}, "https://s3.amazonaws.com/Legacy_Bucket/"},
+		{"johnsmith", []Option{WithDualStack()}, "https://johnsmith.s3.dualstack.us-east-1.amazonaws.com/"},
+		{"johnsmith", []Option{WithAccelerate()}, "https://johnsmith.s3-accelerate.amazonaws.com/"},
+		{"johnsmith", []Option{WithAccelerate(), WithDualStack()}, "https://johnsmith.s3-accelerate.dualstack.amazonaws.com/"},
+		{"johnsmith", []Option{WithEndpoint("http://localhost:9000")}, "http://localhost:9000/johnsmith/"},
+		{"johnsmith", []Option{WithEndpoint("https://ceph.example.com/s3/")}, "https://ceph.example.com/s3/johnsmith/"},
+	} {
+		actual, ok := bucketURLFor(test.bucket, test.opts...)
+		if ok != nil {
+			t.Errorf("%s: %s", test.expected, ok)
+			continue
+		}
+		if actual != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, actual)
+		}
+	}
+}
+
+func TestDegenerateBucketURL(t *testing.T) {
+	for _, test := range []struct {
+		bucket string
+		opts   []Option
+	}{
+		{"", nil},
+		{"Legacy_Bucket", nil},
+		{"192.168.5.4", nil},
+		{"www.example.com", []Option{WithAccelerate()}},
+		{"johnsmith", []Option{WithAccelerate(), WithPathStyle()}},
+		{"johnsmith", []Option{WithEndpoint("localhost:9000")}},
+		{"johnsmith", []Option{WithEndpoint("http://localhost:9000"), WithDualStack()}},
+		{"bad/bucket", []Option{WithPathStyle()}},
+	} {
+		if actual, ok := bucketURLFor(test.bucket, test.opts...); ok == nil {
+			t.Errorf("Bucket %q should be rejected, got %s", test.bucket, actual)
+		}
+	}
+}
+
+func TestUploadToCustomEndpoint(t *testing.T) {
+	uploader, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		testCredentials, testContentType, WithEndpoint("http://localhost:9000"))
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	checkHTTPURLEquals(t, uploader, "http://localhost:9000/johnsmith/")
+	if key := uploader.(*httpUploader).key; key != "user/eric/file1.ext" {
+		t.Errorf("The key should not include the bucket: %s", key)
+	}
+}
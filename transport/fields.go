@@ -0,0 +1,141 @@
+package transport
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+/*
+FormField is a name and value sent as part of an S3 POST form.
+*/
+type FormField struct {
+	Name  string
+	Value string
+}
+
+/*
+fieldOrder ranks the well known form fields.  S3 ignores every field that
+follows file, so file is always written last; the remaining order mirrors
+the examples in the S3 documentation.
+
+http://docs.aws.amazon.com/AmazonS3/latest/dev/HTTPPOSTForms.html#HTTPPOSTFormFields
+*/
+var fieldOrder = map[string]int{
+	"key":                     0,
+	"acl":                     1,
+	"content-type":            2,
+	"cache-control":           3,
+	"content-disposition":     3,
+	"content-encoding":        3,
+	"expires":                 3,
+	"success_action_redirect": 4,
+	"redirect":                4,
+	"success_action_status":   5,
+	"awsaccesskeyid":          8,
+	"policy":                  9,
+	"signature":               10,
+	"x-amz-signature":         10,
+	"file":                    11,
+}
+
+func fieldRank(name string) int {
+	name = strings.ToLower(name)
+	if rank, found := fieldOrder[name]; found {
+		return rank
+	}
+	if strings.HasPrefix(name, "x-amz-meta-") {
+		return 6
+	}
+	return 7
+}
+
+/*
+sortFormFields puts fields into the order S3 requires, keeping the relative
+order of fields with the same rank.
+*/
+func sortFormFields(fields []FormField) {
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fieldRank(fields[i].Name) < fieldRank(fields[j].Name)
+	})
+}
+
+/*
+formFieldName maps a condition name onto the form field it constrains.
+Conditions may name a field with or without the leading $.
+*/
+func formFieldName(condition policy.Condition) string {
+	return strings.TrimPrefix(condition.Name(), "$")
+}
+
+/*
+isFormField reports whether a condition describes a field that is sent in
+the form.  The bucket is part of the URL, ranges constrain the file and the
+signing fields are added by the uploader.
+*/
+func isFormField(condition policy.Condition) bool {
+	if _, isRange := condition.(policy.ConditionRange); isRange {
+		return false
+	}
+	switch strings.ToLower(formFieldName(condition)) {
+	case "bucket", "file", "policy", "signature", "awsaccesskeyid", "x-amz-signature":
+		return false
+	}
+	return true
+}
+
+/*
+FieldsFromPolicy derives the form fields required by the conditions of p, in
+the order S3 requires them.  key is the object key being uploaded; every
+other field takes the exact value, or the prefix, from its condition.  The
+signing fields and file are not included.
+*/
+func FieldsFromPolicy(p *policy.Policy, key string) (fields []FormField) {
+	seen := map[string]bool{}
+	for _, condition := range p.Conditions {
+		if !isFormField(condition) {
+			continue
+		}
+		name := formFieldName(condition)
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+
+		value := condition.ValueString()
+		if strings.EqualFold(name, "key") {
+			value = key
+		}
+		fields = append(fields, FormField{name, value})
+	}
+	sortFormFields(fields)
+	return
+}
+
+/*
+setFormField replaces the value of the named field, adding it when missing.
+*/
+func setFormField(fields []FormField, name, value string) []FormField {
+	for i := range fields {
+		if strings.EqualFold(fields[i].Name, name) {
+			fields[i].Value = value
+			return fields
+		}
+	}
+	fields = append(fields, FormField{name, value})
+	sortFormFields(fields)
+	return fields
+}
+
+/*
+evaluateFields checks the form against the policy before anything is sent,
+so a violation is reported locally rather than as a 403 from S3.
+*/
+func evaluateFields(p *policy.Policy, bucket string, fields []FormField, contentLength int64) error {
+	values := map[string]string{"bucket": bucket}
+	for _, field := range fields {
+		values[field.Name] = field.Value
+	}
+	return p.Evaluate(values, contentLength)
+}
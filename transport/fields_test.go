@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+func TestFieldsFromPolicySkipsNonFormConditions(t *testing.T) {
+	p, _ := policy.ParsePolicy([]byte(`{"expiration": "2007-12-01T12:00:00.000Z",
+		"conditions": [
+			["starts-with", "$x-amz-meta-tag", ""],
+			{"bucket": "johnsmith"},
+			["content-length-range", 1, 10],
+			["eq", "$key", "user/eric/fixed.ext"],
+			{"success_action_status": "201"},
+			{"acl": "private"}
+		]}`))
+	expected := []FormField{
+		{"key", "user/eric/file1.ext"},
+		{"acl", "private"},
+		{"success_action_status", "201"},
+		{"x-amz-meta-tag", ""},
+	}
+	if fields := FieldsFromPolicy(p, "user/eric/file1.ext"); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Unexpected fields.  Expected:\n%v\nActual:\n%v", expected, fields)
+	}
+}
+
+func TestDegenerateUploadViolatesPolicy(t *testing.T) {
+	policyReader := strings.NewReader(`{ "expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    ["content-length-range", 1, 4]
+  ]
+}`)
+	_, ok := NewSingleFileUploader(policyReader, "file1.ext", strings.NewReader("file contents"), testCredentials, testContentType)
+	var evaluation *policy.EvaluationError
+	if !errors.As(ok, &evaluation) {
+		t.Fatalf("Expected an *EvaluationError, got: %v", ok)
+	}
+	if len(evaluation.Violations) != 1 || evaluation.Violations[0].Field != "content-length-range" {
+		t.Errorf("Expected a content-length-range violation: %s", ok)
+	}
+}
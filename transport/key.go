@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+/*
+DefaultKeyTemplate uploads a file under its name after the $key prefix of
+the policy.  A policy that fixes the key with an exact match uploads to
+that key instead.
+*/
+const DefaultKeyTemplate = "{prefix}{filename}"
+
+/*
+WithKeyTemplate chooses the object key from template.  The placeholders are
+
+	{prefix}    the value of the policy's $key condition
+	{filename}  the name of the uploaded file, also accepted as S3's ${filename}
+	{date}      the UTC date of the upload, e.g. 2023-12-31
+	{uuid}      a random version 4 UUID
+	{sha256}    the hex encoded SHA-256 of the file
+
+The rendered key must satisfy the $key condition of the policy.  A template
+can not be used with a presigned form, whose key is fixed by its signature.
+*/
+func WithKeyTemplate(template string) Option {
+	return func(o *Options) {
+		o.keyTemplate = template
+	}
+}
+
+/*
+renderKey expands the key template for filename.  {sha256} reads the whole
+file; file is returned positioned where it was, or replaced by a copy in
+memory when it can not seek.
+*/
+func (o *Options) renderKey(p *policy.Policy, filename string, file io.Reader) (key string, rest io.Reader, ok error) {
+	template := o.keyTemplate
+	condition, constrained := p.Condition("$key")
+	if template == "" {
+		template = DefaultKeyTemplate
+		if _, exact := condition.(policy.ConditionEq); exact {
+			template = "{prefix}"
+		}
+	}
+	template = strings.ReplaceAll(template, "${filename}", "{filename}")
+
+	rest = file
+	var out strings.Builder
+	for {
+		open := strings.Index(template, "{")
+		if open < 0 {
+			out.WriteString(template)
+			break
+		}
+		end := strings.Index(template[open:], "}")
+		if end < 0 {
+			return "", nil, fmt.Errorf("Unterminated placeholder in key template %q.", o.keyTemplate)
+		}
+		out.WriteString(template[:open])
+		placeholder := template[open : open+end+1]
+		template = template[open+end+1:]
+
+		switch placeholder {
+		case "{prefix}":
+			out.WriteString(o.key)
+		case "{filename}":
+			out.WriteString(filename)
+		case "{date}":
+			out.WriteString(o.signingTime().UTC().Format("2006-01-02"))
+		case "{uuid}":
+			uuid, ok := newUUID()
+			if ok != nil {
+				return "", nil, ok
+			}
+			out.WriteString(uuid)
+		case "{sha256}":
+			var sum string
+			if sum, rest, ok = fileSHA256(rest); ok != nil {
+				return "", nil, ok
+			}
+			out.WriteString(sum)
+		default:
+			return "", nil, fmt.Errorf("Unknown placeholder %s in key template %q.", placeholder, o.keyTemplate)
+		}
+	}
+
+	key = out.String()
+	if key == "" {
+		return "", nil, fmt.Errorf("The key template %q renders an empty key.", o.keyTemplate)
+	}
+	if constrained && !condition.Matches("key", key) {
+		return "", nil, fmt.Errorf("The key %q does not satisfy the policy's condition on $key: %s.", key, condition.ValueString())
+	}
+	return key, rest, nil
+}
+
+/*
+newUUID returns a random (version 4) UUID.
+*/
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, ok := rand.Read(u[:]); ok != nil {
+		return "", ok
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]), nil
+}
+
+/*
+fileSHA256 hashes the rest of file and returns a reader over the same bytes.
+*/
+func fileSHA256(file io.Reader) (sum string, rest io.Reader, ok error) {
+	hash := sha256.New()
+	if rest, ok = digestFile(file, hash); ok != nil {
+		return "", nil, ok
+	}
+	return hex.EncodeToString(hash.Sum(nil)), rest, nil
+}
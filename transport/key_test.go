@@ -0,0 +1,95 @@
+package transport
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	file_contents_sha256 = "7bb6f9f7a47a63e684925af3608c059edcc371eb81188c48c9714896fb1091fd"
+	exact_key_policy     = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [{"bucket": "johnsmith"}, ["eq", "$key", "user/eric/fixed.ext"]]}`
+)
+
+func uploadedKey(t *testing.T, policyDoc string, file io.Reader, opts ...Option) string {
+	opts = append(opts, testCredentials, testContentType)
+	uploader, ok := NewSingleFileUploader(strings.NewReader(policyDoc), "file1.ext", file, opts...)
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	_, values := readFormFields(t, uploader.httpRequest())
+	if key := uploader.(*httpUploader).key; key != values["key"] {
+		t.Errorf("The key field %q differs from the uploaded key %q", values["key"], key)
+	}
+	return values["key"]
+}
+
+func TestKeyTemplates(t *testing.T) {
+	now := withSigningTime(time.Date(2023, 12, 31, 23, 0, 0, 0, time.FixedZone("EST", -5*3600)))
+	for template, expected := range map[string]string{
+		"":                               "user/eric/file1.ext",
+		"{prefix}${filename}":            "user/eric/file1.ext",
+		"{prefix}{date}/{filename}":      "user/eric/2024-01-01/file1.ext",
+		"user/eric/{sha256}":             "user/eric/" + file_contents_sha256,
+		"{prefix}archive/{filename}.bak": "user/eric/archive/file1.ext.bak",
+	} {
+		if key := uploadedKey(t, UPLOAD_POLICY_EXAMPLE, strings.NewReader("file contents"), now, WithKeyTemplate(template)); key != expected {
+			t.Errorf("Template %q: expected %q, got %q", template, expected, key)
+		}
+	}
+}
+
+func TestKeyTemplateUUID(t *testing.T) {
+	key := uploadedKey(t, UPLOAD_POLICY_EXAMPLE, strings.NewReader("file contents"), WithKeyTemplate("{prefix}{uuid}"))
+	if !regexp.MustCompile(`^user/eric/[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(key) {
+		t.Errorf("Expected a version 4 UUID: %s", key)
+	}
+}
+
+func TestKeyTemplateSHA256KeepsFile(t *testing.T) {
+	for name, file := range map[string]io.Reader{
+		"seekable":   strings.NewReader("file contents"),
+		"unseekable": io.MultiReader(strings.NewReader("file contents")),
+	} {
+		uploader, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", file,
+			testCredentials, testContentType, WithKeyTemplate("{prefix}{sha256}"))
+		if ok != nil {
+			t.Fatalf("%s: Unable to create a SingleFileUploader: %s", name, ok)
+		}
+		if _, values := readFormFields(t, uploader.httpRequest()); values["file"] != "file contents" {
+			t.Errorf("%s: Hashing should not consume the file: %q", name, values["file"])
+		}
+	}
+}
+
+func TestKeyExactCondition(t *testing.T) {
+	if key := uploadedKey(t, exact_key_policy, strings.NewReader("file contents")); key != "user/eric/fixed.ext" {
+		t.Errorf("An exact $key condition should fix the key: %s", key)
+	}
+}
+
+func TestDegenerateKeyTemplates(t *testing.T) {
+	for _, template := range []string{
+		"other/{filename}",
+		"{prefix}{nope}",
+		"{prefix}{filename",
+	} {
+		_, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+			testCredentials, testContentType, WithKeyTemplate(template))
+		if ok == nil {
+			t.Errorf("Template %q should be rejected", template)
+		}
+	}
+}
+
+func TestDegenerateKeyTemplateWithPresignedForm(t *testing.T) {
+	form := []FormField{{"key", "user/eric/${filename}"}, {"Policy", "cG9saWN5"}, {"Signature", "c2lnbmF0dXJl"}}
+	_, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		WithPresignedForm("https://johnsmith.s3.amazonaws.com/", form), WithKeyTemplate("{prefix}{uuid}"))
+	if ok == nil {
+		t.Errorf("A key template should be rejected with a presigned form")
+	}
+}
@@ -0,0 +1,65 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+/*
+WithPresignedForm uploads with the policy and signature of an existing
+form, typically one scraped from a web page, instead of signing locally.
+fields are the form's inputs, posted as given, and action is the URL the
+form posts to.  No credentials are needed.
+*/
+func WithPresignedForm(action string, fields []FormField) Option {
+	return func(o *Options) {
+		o.action = action
+		o.presigned = fields
+	}
+}
+
+/*
+presignedForm posts the form's own fields to its action.  S3 replaces
+${filename} in the key with the name of the uploaded file.
+*/
+func (o *Options) presignedForm(filename string) (uploadURL *url.URL, key string, fields []FormField, ok error) {
+	if uploadURL, ok = url.Parse(o.action); ok != nil {
+		return
+	}
+	if uploadURL.Scheme == "" || uploadURL.Host == "" {
+		return nil, "", nil, errors.New("The form action must be an absolute URL.")
+	}
+
+	for _, field := range o.presigned {
+		if strings.EqualFold(field.Name, "file") {
+			continue
+		}
+		if strings.EqualFold(field.Name, "key") {
+			key = strings.ReplaceAll(field.Value, "${filename}", filename)
+		}
+		fields = append(fields, field)
+	}
+	if key == "" {
+		return nil, "", nil, errors.New("The form has no key field.")
+	}
+	sortFormFields(fields)
+	return
+}
+
+/*
+presignedContentType is the type given by the form's Content-Type field,
+which S3 checks against the page's policy, or else the detected type.
+*/
+func (o *Options) presignedContentType(filename string, file io.Reader) (contentType string, rest io.Reader, ok error) {
+	for _, field := range o.presigned {
+		if strings.EqualFold(field.Name, "content-type") && field.Value != "" {
+			return field.Value, file, nil
+		}
+	}
+	if o.contentType != "" {
+		return o.contentType, file, nil
+	}
+	return DetectContentType(filename, file)
+}
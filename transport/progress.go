@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"io"
+)
+
+/*
+ProgressFunc is called as the form is sent with the number of bytes of the
+request body sent so far and its total length.  It is called from the
+goroutine writing the request, so it must not block.
+*/
+type ProgressFunc func(sent, total int64)
+
+/*
+WithProgress reports the progress of the upload to fn.  When a request is
+retried the count starts again from zero.
+*/
+func WithProgress(fn ProgressFunc) Option {
+	return func(o *Options) {
+		o.progress = fn
+	}
+}
+
+/*
+progressReader counts the bytes read from the request body.
+*/
+type progressReader struct {
+	io.ReadCloser
+	progress ProgressFunc
+	sent     int64
+	total    int64
+}
+
+func (p *progressReader) Read(b []byte) (n int, ok error) {
+	n, ok = p.ReadCloser.Read(b)
+	if n > 0 {
+		p.sent += int64(n)
+		p.progress(p.sent, p.total)
+	}
+	return
+}
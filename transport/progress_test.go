@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestUploadProgress(t *testing.T) {
+	var reports [][2]int64
+	progress := WithProgress(func(sent, total int64) {
+		reports = append(reports, [2]int64{sent, total})
+	})
+	uploader, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", &zeroFile{size: 1 << 20}, testCredentials, testContentType, progress)
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	if len(reports) != 0 {
+		t.Errorf("Progress should not be reported before the upload starts")
+	}
+
+	request := uploader.httpRequest()
+	io.Copy(io.Discard, request.Body)
+	if len(reports) < 2 {
+		t.Fatalf("Expected progress while the body is read, got %v", reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i][0] <= reports[i-1][0] {
+			t.Errorf("Progress should increase: %v then %v", reports[i-1], reports[i])
+		}
+	}
+	last := reports[len(reports)-1]
+	if last[0] != request.ContentLength || last[1] != request.ContentLength {
+		t.Errorf("Expected the final report to be %d of %d, got %v", request.ContentLength, request.ContentLength, last)
+	}
+
+	reports = nil
+	body, _ := request.GetBody()
+	io.Copy(io.Discard, body)
+	if reports[0][0] > 1<<20 {
+		t.Errorf("A rewound body should count from zero, got %v", reports[0])
+	}
+}
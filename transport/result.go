@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+UploadResult describes an object S3 accepted.  The bucket, key and ETag are
+those S3 reports, in the redirect to success_action_redirect or the
+PostResponse of a 201, falling back to the form and the response headers.
+*/
+type UploadResult struct {
+	StatusCode int
+	ETag       string
+	Location   string
+	Bucket     string
+	Key        string
+	Checksums  Checksums
+}
+
+/*
+postResponse is the body S3 returns when success_action_status is 201.
+*/
+type postResponse struct {
+	Location string `xml:"Location"`
+	Bucket   string `xml:"Bucket"`
+	Key      string `xml:"Key"`
+	ETag     string `xml:"ETag"`
+}
+
+/*
+newUploadResult reads the outcome of a successful POST.  S3 answers with a
+303 to success_action_redirect, carrying bucket, key and etag in the query
+of the Location, or with success_action_status: 200 or 204 with empty
+bodies, or 201 with a PostResponse document.
+*/
+func newUploadResult(response *http.Response, bucket, key string) (result *UploadResult, ok error) {
+	result = &UploadResult{
+		StatusCode: response.StatusCode,
+		ETag:       response.Header.Get("ETag"),
+		Location:   response.Header.Get("Location"),
+		Bucket:     bucket,
+		Key:        key,
+	}
+
+	switch response.StatusCode {
+	case http.StatusSeeOther:
+		redirect, ok := url.Parse(result.Location)
+		if ok != nil {
+			return nil, fmt.Errorf("Invalid redirect to success_action_redirect %q: %s", result.Location, ok)
+		}
+		query := redirect.Query()
+		result.setReported(query.Get("bucket"), query.Get("key"), query.Get("etag"))
+	case http.StatusCreated:
+		var created postResponse
+		body, ok := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+		if ok != nil {
+			return nil, ok
+		}
+		if ok = xml.Unmarshal(body, &created); ok != nil {
+			return nil, fmt.Errorf("Invalid PostResponse from S3: %s", ok)
+		}
+		result.setReported(created.Bucket, created.Key, created.ETag)
+		if created.Location != "" {
+			result.Location = created.Location
+		}
+	}
+	result.ETag = strings.Trim(result.ETag, `"`)
+	return result, nil
+}
+
+func (r *UploadResult) setReported(bucket, key, etag string) {
+	if bucket != "" {
+		r.Bucket = bucket
+	}
+	if key != "" {
+		r.Key = key
+	}
+	if etag != "" {
+		r.ETag = etag
+	}
+}
+
+/*
+S3Error is the error document S3 returns when it rejects an upload.
+
+http://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html
+*/
+type S3Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestId  string `xml:"RequestId"`
+	HostId     string `xml:"HostId"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 upload failed: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("S3 upload failed: %d %s: %s (RequestId: %s)", e.StatusCode, e.Code, e.Message, e.RequestId)
+}
+
+/*
+newS3Error decodes the error document in response.  Responses without one,
+for example from a proxy, still produce an *S3Error carrying the status.
+*/
+func newS3Error(response *http.Response) error {
+	s3err := &S3Error{StatusCode: response.StatusCode}
+	body, ok := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if ok != nil {
+		return ok
+	}
+	xml.Unmarshal(body, s3err)
+	if s3err.RequestId == "" {
+		s3err.RequestId = response.Header.Get("x-amz-request-id")
+	}
+	if s3err.HostId == "" {
+		s3err.HostId = response.Header.Get("x-amz-id-2")
+	}
+	return s3err
+}
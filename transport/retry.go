@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+/*
+RetryPolicy controls how an upload is retried after a transient failure:
+a reset connection, a 500 or 503 response or a SlowDown error.  The delay
+before attempt n+1 is chosen at random between zero and
+BaseDelay*2^(n-1), capped at MaxDelay ("full jitter").
+
+https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+*/
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+/*
+DefaultRetryPolicy is used unless WithRetryPolicy is given.
+*/
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   200 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+/*
+WithRetryPolicy replaces DefaultRetryPolicy.  A MaxAttempts of 1 disables
+retries.
+*/
+func WithRetryPolicy(retry RetryPolicy) Option {
+	return func(o *Options) {
+		o.retry = retry
+	}
+}
+
+/*
+delay is the time to wait after the given failed attempt.
+*/
+func (r RetryPolicy) delay(attempt int) time.Duration {
+	backoff := r.MaxDelay
+	if attempt-1 < 32 {
+		if exp := r.BaseDelay << uint(attempt-1); exp > 0 && exp < r.MaxDelay {
+			backoff = exp
+		}
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+/*
+isRetryable reports whether ok is a transient failure worth retrying.  An
+unexpected EOF is retried only when the connection ended early, not when the
+file being uploaded did.
+*/
+func isRetryable(ok error) bool {
+	if errors.Is(ok, context.Canceled) || errors.Is(ok, context.DeadlineExceeded) {
+		return false
+	}
+	var fileErr *fileError
+	if errors.As(ok, &fileErr) {
+		return false
+	}
+	var s3err *S3Error
+	if errors.As(ok, &s3err) {
+		return s3err.StatusCode == http.StatusInternalServerError ||
+			s3err.StatusCode == http.StatusServiceUnavailable ||
+			s3err.Code == "SlowDown"
+	}
+	if errors.Is(ok, syscall.ECONNRESET) || errors.Is(ok, syscall.EPIPE) ||
+		errors.Is(ok, io.EOF) || errors.Is(ok, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(ok, &netErr) && netErr.Timeout()
+}
+
+/*
+sleep waits for d or until ctx is done.
+*/
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,180 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var fastRetry = WithRetryPolicy(RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond})
+
+/*
+faultServer answers each request with the next fault and succeeds once the
+faults run out.  It records the file received by every attempt.
+*/
+type faultServer struct {
+	mu     sync.Mutex
+	faults []func(w http.ResponseWriter)
+	files  []string
+}
+
+func resetConnection(w http.ResponseWriter) {
+	conn, _, _ := w.(http.Hijacker).Hijack()
+	conn.Close()
+}
+
+func slowDown(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(`<Error><Code>SlowDown</Code><Message>Please reduce your request rate.</Message></Error>`))
+}
+
+func internalError(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(`<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>`))
+}
+
+func accessDenied(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+}
+
+func (f *faultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files = append(f.files, readFilePart(r))
+	if len(f.faults) > 0 {
+		fault := f.faults[0]
+		f.faults = f.faults[1:]
+		fault(w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readFilePart(r *http.Request) string {
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, ok := reader.NextPart()
+		if ok != nil {
+			return ""
+		}
+		if part.FormName() == "file" {
+			contents, _ := io.ReadAll(part)
+			return string(contents)
+		}
+	}
+}
+
+func TestUploadRetriesTransientFailures(t *testing.T) {
+	server := &faultServer{faults: []func(http.ResponseWriter){resetConnection, slowDown, internalError}}
+	client := newTestClient(t, server.ServeHTTP)
+	uploader, _ := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		WithHTTPClient(client), testCredentials, testContentType, fastRetry)
+
+	if _, ok := uploader.Upload(context.Background()); ok != nil {
+		t.Fatalf("Upload should succeed after retrying: %s", ok)
+	}
+	if len(server.files) != 4 {
+		t.Fatalf("Expected 4 attempts, got %d", len(server.files))
+	}
+	for i, file := range server.files {
+		if file != "file contents" {
+			t.Errorf("Attempt %d did not send the full file: %q", i+1, file)
+		}
+	}
+}
+
+func TestDegenerateUploadRetriesExhausted(t *testing.T) {
+	server := &faultServer{faults: []func(http.ResponseWriter){slowDown, slowDown, slowDown, slowDown, slowDown}}
+	client := newTestClient(t, server.ServeHTTP)
+	uploader, _ := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		WithHTTPClient(client), testCredentials, testContentType, fastRetry)
+
+	_, ok := uploader.Upload(context.Background())
+	var s3err *S3Error
+	if !errors.As(ok, &s3err) || s3err.Code != "SlowDown" {
+		t.Errorf("Expected the last SlowDown error, got: %v", ok)
+	}
+	if len(server.files) != 4 {
+		t.Errorf("Expected MaxAttempts attempts, got %d", len(server.files))
+	}
+}
+
+func TestDegenerateUploadNotRetried(t *testing.T) {
+	server := &faultServer{faults: []func(http.ResponseWriter){accessDenied}}
+	client := newTestClient(t, server.ServeHTTP)
+	uploader, _ := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		WithHTTPClient(client), testCredentials, testContentType, fastRetry)
+
+	if _, ok := uploader.Upload(context.Background()); ok == nil {
+		t.Errorf("AccessDenied should fail the upload")
+	}
+	if len(server.files) != 1 {
+		t.Errorf("AccessDenied should not be retried, got %d attempts", len(server.files))
+	}
+}
+
+func TestDegenerateUploadTruncatedFileNotRetried(t *testing.T) {
+	server := &faultServer{}
+	client := newTestClient(t, server.ServeHTTP)
+	file := &zeroFile{size: 1024}
+	uploader, _ := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", file,
+		WithHTTPClient(client), testCredentials, testContentType, fastRetry)
+	file.size = 512
+
+	if _, ok := uploader.Upload(context.Background()); !errors.Is(ok, io.ErrUnexpectedEOF) {
+		t.Errorf("A truncated file should fail the upload, got: %v", ok)
+	}
+	if len(server.files) > 1 {
+		t.Errorf("A truncated file should not be retried, got %d attempts", len(server.files))
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for ok, expected := range map[error]bool{
+		io.ErrUnexpectedEOF:                    true,
+		&fileError{io.ErrUnexpectedEOF}:        false,
+		&fileError{errors.New("disk failure")}: false,
+		&S3Error{StatusCode: 503}:              true,
+		context.Canceled:                       false,
+	} {
+		if isRetryable(ok) != expected {
+			t.Errorf("isRetryable(%v) should be %v", ok, expected)
+		}
+	}
+}
+
+func TestDegenerateUploadCanceledDuringBackoff(t *testing.T) {
+	server := &faultServer{faults: []func(http.ResponseWriter){slowDown}}
+	client := newTestClient(t, server.ServeHTTP)
+	slowRetry := WithRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Hour, MaxDelay: time.Hour})
+	uploader, _ := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		WithHTTPClient(client), testCredentials, testContentType, slowRetry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, ok := uploader.Upload(ctx); !errors.Is(ok, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline to end the backoff, got: %v", ok)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	retry := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 1; attempt < 40; attempt++ {
+		limit := retry.MaxDelay
+		if attempt < 5 {
+			limit = retry.BaseDelay << uint(attempt-1)
+		}
+		if delay := retry.delay(attempt); delay < 0 || delay > limit {
+			t.Errorf("Delay after attempt %d out of range: %s > %s", attempt, delay, limit)
+		}
+	}
+}
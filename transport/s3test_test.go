@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/s3test"
+)
+
+const (
+	s3test_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    {"acl": "public-read"},
+    ["content-length-range", 1, 1024]
+  ]
+}`
+	s3test_token_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    {"x-amz-security-token": "token"}
+  ]
+}`
+)
+
+/*
+newS3TestServer accepts uploads signed by foobar/barfoo, and by
+temporary/secret with session token "token", in December 2007.
+*/
+func newS3TestServer(t *testing.T, opts ...s3test.Option) *s3test.Server {
+	opts = append([]s3test.Option{
+		s3test.WithCredentials(
+			credentials.Value{AccessKeyId: "foobar", SecretAccessKey: "barfoo"},
+			credentials.Value{AccessKeyId: "temporary", SecretAccessKey: "secret", SessionToken: "token"}),
+		s3test.WithClock(func() time.Time { return time.Date(2007, 11, 30, 0, 0, 0, 0, time.UTC) }),
+	}, opts...)
+	server := s3test.NewServer(opts...)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func uploadTo(t *testing.T, server *s3test.Server, policyDoc string, opts ...Option) (*UploadResult, error) {
+	opts = append([]Option{WithHTTPClient(server.Client()), testCredentials}, opts...)
+	uploader, ok := NewSingleFileUploader(strings.NewReader(policyDoc), "file1.ext", strings.NewReader("file contents"), opts...)
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	return uploader.Upload(context.Background())
+}
+
+func checkStored(t *testing.T, server *s3test.Server, result *UploadResult) {
+	object, found := server.Object("johnsmith", "user/eric/file1.ext")
+	if !found {
+		t.Fatalf("Object not stored: %v", server.Objects())
+	}
+	if contents, _ := object.Contents(); string(contents) != "file contents" {
+		t.Errorf("Unexpected contents: %q", contents)
+	}
+	if result.ETag != object.ETag || result.Key != "user/eric/file1.ext" || result.Bucket != "johnsmith" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestS3TestUploadSignatureV2(t *testing.T) {
+	server := newS3TestServer(t)
+	result, ok := uploadTo(t, server, s3test_policy)
+	if ok != nil {
+		t.Fatalf("Upload failed: %s", ok)
+	}
+	checkStored(t, server, result)
+	if result.StatusCode != http.StatusNoContent || result.ETag != "4a8ec4fa5f01b4ab1a0ab8cbccb709f0" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestS3TestUploadSignatureV4(t *testing.T) {
+	server := newS3TestServer(t)
+	result, ok := uploadTo(t, server, s3test_policy, WithSignatureVersion(SignatureV4), WithRegion("eu-west-1"))
+	if ok != nil {
+		t.Fatalf("Upload failed: %s", ok)
+	}
+	checkStored(t, server, result)
+}
+
+func TestS3TestUploadSessionToken(t *testing.T) {
+	server := newS3TestServer(t)
+	temporary := WithCredentials(credentials.NewStatic("temporary", "secret", "token"))
+	if _, ok := uploadTo(t, server, s3test_token_policy, temporary); ok != nil {
+		t.Errorf("Signature Version 2 upload failed: %s", ok)
+	}
+	if _, ok := uploadTo(t, server, s3test_policy, temporary, WithSignatureVersion(SignatureV4)); ok != nil {
+		t.Errorf("Signature Version 4 upload failed: %s", ok)
+	}
+}
+
+func TestS3TestUploadPathStyle(t *testing.T) {
+	server := newS3TestServer(t, s3test.WithDir(t.TempDir()))
+	result, ok := uploadTo(t, server, s3test_policy, WithEndpoint(server.URL))
+	if ok != nil {
+		t.Fatalf("Upload failed: %s", ok)
+	}
+	checkStored(t, server, result)
+	if result.Location != server.URL+"/johnsmith/user/eric/file1.ext" {
+		t.Errorf("Unexpected location: %s", result.Location)
+	}
+}
+
+func TestS3TestBatch(t *testing.T) {
+	server := newS3TestServer(t)
+	root := t.TempDir()
+	writeFiles(t, root, "a.txt", "sub/b.txt")
+	os.WriteFile(filepath.Join(root, "empty.txt"), nil, 0644)
+	files, _ := WalkFiles([]string{root})
+
+	batch := &Batch{Policy: []byte(s3test_policy), Options: []Option{WithHTTPClient(server.Client()), testCredentials}}
+	for _, result := range batch.Upload(context.Background(), files) {
+		if (result.Err != nil) != (result.File.Name == "empty.txt") {
+			t.Errorf("Unexpected outcome for %s: %v", result.File.Name, result.Err)
+		}
+	}
+	if objects := server.Objects(); len(objects) != 2 || objects[1].Key != "user/eric/sub/b.txt" {
+		t.Errorf("Unexpected objects: %v", objects)
+	}
+}
+
+func checkS3Error(t *testing.T, ok error, status int, code string) {
+	var s3err *S3Error
+	if !errors.As(ok, &s3err) {
+		t.Fatalf("Expected an *S3Error, got: %v", ok)
+	}
+	if s3err.StatusCode != status || s3err.Code != code || s3err.RequestId == "" {
+		t.Errorf("Expected %d %s, got: %+v", status, code, s3err)
+	}
+}
+
+func TestDegenerateS3TestWrongSecret(t *testing.T) {
+	server := newS3TestServer(t)
+	wrong := WithCredentials(credentials.NewStatic("foobar", "wrong", ""))
+	_, ok := uploadTo(t, server, s3test_policy, wrong)
+	checkS3Error(t, ok, http.StatusForbidden, "SignatureDoesNotMatch")
+
+	_, ok = uploadTo(t, server, s3test_policy, wrong, WithSignatureVersion(SignatureV4))
+	checkS3Error(t, ok, http.StatusForbidden, "SignatureDoesNotMatch")
+}
+
+func TestDegenerateS3TestUnknownAccessKey(t *testing.T) {
+	server := newS3TestServer(t)
+	_, ok := uploadTo(t, server, s3test_policy, WithCredentials(credentials.NewStatic("unknown", "barfoo", "")))
+	checkS3Error(t, ok, http.StatusForbidden, "InvalidAccessKeyId")
+}
+
+func TestDegenerateS3TestExpiredPolicy(t *testing.T) {
+	server := newS3TestServer(t, s3test.WithClock(time.Now))
+	_, ok := uploadTo(t, server, s3test_policy)
+	checkS3Error(t, ok, http.StatusForbidden, "AccessDenied")
+}
+
+func TestDegenerateS3TestNoSuchBucket(t *testing.T) {
+	server := newS3TestServer(t, s3test.WithBuckets("other"))
+	_, ok := uploadTo(t, server, s3test_policy)
+	checkS3Error(t, ok, http.StatusNotFound, "NoSuchBucket")
+}
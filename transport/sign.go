@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"errors"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+const (
+	// SignatureV2 is the legacy HMAC-SHA1 policy signature.
+	SignatureV2 = 2
+	// SignatureV4 is AWS Signature Version 4, required by regions launched
+	// after January 2014.
+	SignatureV4 = 4
+)
+
+/*
+WithSignatureVersion selects SignatureV2 (the default) or SignatureV4.
+*/
+func WithSignatureVersion(version int) Option {
+	return func(o *Options) {
+		o.signatureVersion = version
+	}
+}
+
+/*
+WithRegion sets the region uploads are sent to and Signature Version 4
+signatures are scoped to.  It defaults to us-east-1.
+*/
+func WithRegion(region string) Option {
+	return func(o *Options) {
+		o.region = region
+	}
+}
+
+/*
+WithClock sets the time Signature Version 4 signatures and {date} keys are
+made at.  It defaults to time.Now.
+*/
+func WithClock(now func() time.Time) Option {
+	return func(o *Options) {
+		o.now = now
+	}
+}
+
+func (o *Options) signingTime() time.Time {
+	if o.now != nil {
+		return o.now()
+	}
+	return time.Now()
+}
+
+/*
+signOptionsFromPolicy signs p with creds using the selected signature
+version.  Signature Version 4 adds its signing conditions to p.
+*/
+func (o *Options) signOptionsFromPolicy(p *policy.Policy, creds credentials.Value) (ok error) {
+	var enc, sig []byte
+	switch o.signatureVersion {
+	case 0, SignatureV2:
+		o.signatureVersion = SignatureV2
+		if creds.SessionToken != "" {
+			if _, found := p.Condition("x-amz-security-token"); !found {
+				return errors.New("Temporary credentials require an x-amz-security-token condition in the policy.")
+			}
+		}
+		signer, ok := policy.NewS3DropboxSigner(creds.AccessKeyId, creds.SecretAccessKey)
+		if ok != nil {
+			return ok
+		}
+		signer.AddPolicy(p)
+		if enc, sig, ok = signer.Sign(); ok != nil {
+			return ok
+		}
+	case SignatureV4:
+		region := o.region
+		if region == "" {
+			region = "us-east-1"
+		}
+		signer, ok := policy.NewSigV4Signer(creds.AccessKeyId, creds.SecretAccessKey, region, o.signingTime())
+		if ok != nil {
+			return ok
+		}
+		signer.SetSessionToken(creds.SessionToken)
+		signer.AddPolicy(p)
+		if enc, sig, ok = signer.Sign(); ok != nil {
+			return ok
+		}
+	default:
+		return errors.New("Unsupported signature version.  Use SignatureV2 or SignatureV4.")
+	}
+	o.awsAccessKeyId = creds.AccessKeyId
+	o.policyEncoded = enc
+	o.signature = sig
+	return nil
+}
+
+/*
+signatureFields are the form fields that carry the signature.  The
+Signature Version 4 credential scope is part of the policy conditions.
+*/
+func (o *Options) signatureFields() []FormField {
+	if o.signatureVersion == SignatureV4 {
+		return []FormField{
+			{"policy", string(o.policyEncoded)},
+			{"x-amz-signature", string(o.signature)},
+		}
+	}
+	return []FormField{
+		{"AWSAccessKeyId", o.awsAccessKeyId},
+		{"policy", string(o.policyEncoded)},
+		{"signature", string(o.signature)},
+	}
+}
+
+/*
+SignPolicy signs p with creds, as selected by WithSignatureVersion,
+WithRegion and WithClock, the same way an upload is signed, and returns the
+form fields that carry the signature.  Signature Version 4 adds its signing
+conditions to p; FieldsFromPolicy returns them with the rest of the form.
+*/
+func SignPolicy(p *policy.Policy, creds credentials.Value, opts ...Option) (fields []FormField, ok error) {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if ok = o.signOptionsFromPolicy(p, creds); ok != nil {
+		return nil, ok
+	}
+	return o.signatureFields(), nil
+}
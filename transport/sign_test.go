@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
+	"github.com/noahcampbell/s3dropbox/policy"
+)
+
+var signingTime = time.Date(2015, time.December, 29, 13, 14, 15, 0, time.UTC)
+
+func withSigningTime(now time.Time) Option {
+	return func(o *Options) {
+		o.now = func() time.Time { return now }
+	}
+}
+
+func TestSignatureV4FormFields(t *testing.T) {
+	uploader, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		testCredentials, testContentType, WithSignatureVersion(SignatureV4), WithRegion("eu-west-1"), withSigningTime(signingTime))
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	names, values := readFormFields(t, uploader.httpRequest())
+
+	expectedNames := []string{"key", "acl", "Content-Type", "success_action_redirect", "x-amz-meta-uuid", "x-amz-meta-tag",
+		"x-amz-algorithm", "x-amz-credential", "x-amz-date", "policy", "x-amz-signature", "file"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("Form fields out of order.  Expected:\n%v\nActual:\n%v", expectedNames, names)
+	}
+	if values["x-amz-algorithm"] != "AWS4-HMAC-SHA256" {
+		t.Errorf("Unexpected algorithm: %s", values["x-amz-algorithm"])
+	}
+	if values["x-amz-credential"] != "foobar/20151229/eu-west-1/s3/aws4_request" {
+		t.Errorf("Unexpected credential: %s", values["x-amz-credential"])
+	}
+	if values["x-amz-date"] != "20151229T131415Z" {
+		t.Errorf("Unexpected date: %s", values["x-amz-date"])
+	}
+
+	hasher := hmac.New(sha256.New, policy.DeriveSigningKey("barfoo", signingTime, "eu-west-1", "s3"))
+	hasher.Write([]byte(values["policy"]))
+	if expected := hex.EncodeToString(hasher.Sum(nil)); values["x-amz-signature"] != expected {
+		t.Errorf("Signature does not match the policy.  Expected: %s Actual: %s", expected, values["x-amz-signature"])
+	}
+}
+
+func TestSignatureV4SessionToken(t *testing.T) {
+	temporary := WithCredentials(credentials.NewStatic("foobar", "barfoo", "token"))
+	uploader, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		temporary, testContentType, WithSignatureVersion(SignatureV4))
+	if ok != nil {
+		t.Fatalf("Signature Version 4 should add the session token to the policy: %s", ok)
+	}
+	if _, values := readFormFields(t, uploader.httpRequest()); values["x-amz-security-token"] != "token" {
+		t.Errorf("Session token not sent: %v", values)
+	}
+}
+
+func TestDegenerateUnsupportedSignatureVersion(t *testing.T) {
+	if _, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"),
+		testCredentials, testContentType, WithSignatureVersion(3)); ok == nil {
+		t.Errorf("Signature version 3 should be an error")
+	}
+}
+
+func TestSignPolicy(t *testing.T) {
+	p, _ := policy.NewPolicy(time.Date(2015, time.December, 30, 12, 0, 0, 0, time.UTC))
+	p.AddConditionEq("bucket", "sigv4examplebucket")
+	p.AddConditionStartsWith("$key", "user/user1/")
+	fields, ok := SignPolicy(p, credentials.Value{AccessKeyId: "foobar", SecretAccessKey: "barfoo"},
+		WithSignatureVersion(SignatureV4), WithRegion("eu-west-1"), withSigningTime(signingTime))
+	if ok != nil {
+		t.Fatalf("Unable to sign the policy: %s", ok)
+	}
+	if len(fields) != 2 || fields[0].Name != "policy" || fields[1].Name != "x-amz-signature" {
+		t.Fatalf("Unexpected signature fields: %v", fields)
+	}
+	if !p.ConditionMatches("x-amz-credential", "foobar/20151229/eu-west-1/s3/aws4_request") {
+		t.Errorf("The credential scope should be added to the policy: %v", p.Conditions)
+	}
+
+	hasher := hmac.New(sha256.New, policy.DeriveSigningKey("barfoo", signingTime, "eu-west-1", "s3"))
+	hasher.Write([]byte(fields[0].Value))
+	if expected := hex.EncodeToString(hasher.Sum(nil)); fields[1].Value != expected {
+		t.Errorf("Signature does not match the policy.  Expected: %s Actual: %s", expected, fields[1].Value)
+	}
+}
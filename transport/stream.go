@@ -0,0 +1,228 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+/*
+multipartBody streams a form without holding the file in memory.  The
+fields and the file part header are rendered up front into prefix and the
+closing boundary into suffix, so the length of the body is known before the
+file is read.
+*/
+type multipartBody struct {
+	prefix   []byte
+	suffix   []byte
+	file     io.Reader
+	size     int64
+	start    int64
+	boundary string
+	progress ProgressFunc
+	sent     sentChecksums
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+/*
+switchWriter lets the multipart writer render into prefix and then suffix.
+*/
+type switchWriter struct {
+	io.Writer
+}
+
+/*
+fileSize reports how many bytes remain in r when that can be known without
+reading it.
+*/
+func fileSize(r io.Reader) (size int64, known bool) {
+	switch f := r.(type) {
+	case interface{ Size() int64 }:
+		if seeker, isSeeker := r.(io.Seeker); isSeeker {
+			if offset, ok := seeker.Seek(0, io.SeekCurrent); ok == nil {
+				return f.Size() - offset, true
+			}
+		}
+		return f.Size(), true
+	case *os.File:
+		info, ok := f.Stat()
+		if ok != nil || !info.Mode().IsRegular() {
+			return 0, false
+		}
+	}
+	seeker, isSeeker := r.(io.Seeker)
+	if !isSeeker {
+		return 0, false
+	}
+	offset, ok := seeker.Seek(0, io.SeekCurrent)
+	if ok != nil {
+		return 0, false
+	}
+	end, ok := seeker.Seek(0, io.SeekEnd)
+	if ok != nil {
+		return 0, false
+	}
+	if _, ok = seeker.Seek(offset, io.SeekStart); ok != nil {
+		return 0, false
+	}
+	return end - offset, true
+}
+
+/*
+newMultipartBody renders fields followed by the file part.  A file whose
+size can not be determined, such as a pipe, is read into memory so that
+the Content-Length S3 requires can still be sent.
+*/
+func newMultipartBody(fields []FormField, filename, contentType string, file io.Reader) (body *multipartBody, ok error) {
+	body = &multipartBody{file: file}
+	size, known := fileSize(file)
+	if !known {
+		buffered, ok := io.ReadAll(file)
+		if ok != nil {
+			return nil, ok
+		}
+		body.file = bytes.NewReader(buffered)
+		size = int64(len(buffered))
+	}
+	body.size = size
+	if seeker, isSeeker := body.file.(io.Seeker); isSeeker {
+		if body.start, ok = seeker.Seek(0, io.SeekCurrent); ok != nil {
+			return nil, ok
+		}
+	}
+
+	var prefix, suffix bytes.Buffer
+	out := &switchWriter{&prefix}
+	writer := multipart.NewWriter(out)
+	for _, field := range fields {
+		if ok = writer.WriteField(field.Name, field.Value); ok != nil {
+			return nil, ok
+		}
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(filename)))
+	header.Set("Content-Type", contentType)
+	if _, ok = writer.CreatePart(header); ok != nil {
+		return nil, ok
+	}
+	out.Writer = &suffix
+	if ok = writer.Close(); ok != nil {
+		return nil, ok
+	}
+
+	body.prefix = prefix.Bytes()
+	body.suffix = suffix.Bytes()
+	body.boundary = writer.Boundary()
+	return body, nil
+}
+
+/*
+ContentLength is the exact length of the encoded form.
+*/
+func (b *multipartBody) ContentLength() int64 {
+	return int64(len(b.prefix)) + b.size + int64(len(b.suffix))
+}
+
+func (b *multipartBody) ContentType() string {
+	return fmt.Sprintf("multipart/form-data; boundary=%s", b.boundary)
+}
+
+/*
+Reader streams the form.  It fails if the file does not hold exactly the
+number of bytes announced in the Content-Length.
+*/
+func (b *multipartBody) Reader() io.ReadCloser {
+	reader := io.NopCloser(io.MultiReader(
+		bytes.NewReader(b.prefix),
+		newChecksumReader(&exactReader{b.file, b.size}, b.sent.set),
+		bytes.NewReader(b.suffix),
+	))
+	if b.progress == nil {
+		return reader
+	}
+	return &progressReader{ReadCloser: reader, progress: b.progress, total: b.ContentLength()}
+}
+
+/*
+Checksums are the digests of the file, once the whole of it has been read.
+*/
+func (b *multipartBody) Checksums() (sums Checksums, complete bool) {
+	return b.sent.get()
+}
+
+/*
+Rewindable reports whether the body can be sent again, which requires
+seeking the file back to where the upload started.
+*/
+func (b *multipartBody) Rewindable() bool {
+	_, isSeeker := b.file.(io.Seeker)
+	return isSeeker
+}
+
+/*
+Rewind returns a fresh reader over the whole form, for http.Request.GetBody.
+*/
+func (b *multipartBody) Rewind() (io.ReadCloser, error) {
+	seeker, isSeeker := b.file.(io.Seeker)
+	if !isSeeker {
+		return nil, fmt.Errorf("The file being uploaded can not be reread.")
+	}
+	if _, ok := seeker.Seek(b.start, io.SeekStart); ok != nil {
+		return nil, ok
+	}
+	return b.Reader(), nil
+}
+
+/*
+fileError is a failure to read the file being uploaded, as opposed to a
+failure of the connection.  Sending the file again would fail the same way,
+so it is never retried.
+*/
+type fileError struct {
+	ok error
+}
+
+func (e *fileError) Error() string {
+	if e.ok == io.ErrUnexpectedEOF {
+		return "The file is shorter than the size announced for the upload."
+	}
+	return fmt.Sprintf("Unable to read the file being uploaded: %s", e.ok)
+}
+
+func (e *fileError) Unwrap() error {
+	return e.ok
+}
+
+/*
+exactReader reads exactly remaining bytes from r.
+*/
+type exactReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (e *exactReader) Read(p []byte) (n int, ok error) {
+	if e.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > e.remaining {
+		p = p[:e.remaining]
+	}
+	n, ok = e.r.Read(p)
+	e.remaining -= int64(n)
+	if ok == io.EOF && e.remaining > 0 {
+		return n, &fileError{io.ErrUnexpectedEOF}
+	}
+	if ok == io.EOF {
+		ok = nil
+	}
+	if ok != nil {
+		return n, &fileError{ok}
+	}
+	return n, nil
+}
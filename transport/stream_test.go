@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+zeroFile is a file of size zero bytes that is never held in memory.
+*/
+type zeroFile struct {
+	size, offset int64
+}
+
+func (z *zeroFile) Read(p []byte) (int, error) {
+	if z.offset >= z.size {
+		return 0, io.EOF
+	}
+	if remaining := z.size - z.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	z.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (z *zeroFile) Size() int64 {
+	return z.size
+}
+
+func (z *zeroFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += z.offset
+	case io.SeekEnd:
+		offset += z.size
+	}
+	z.offset = offset
+	return offset, nil
+}
+
+func newStreamingUploader(t testing.TB, file io.Reader) Uploader {
+	uploader, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", file, testCredentials, testContentType)
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	return uploader
+}
+
+func TestUploadStreamsFileWithLength(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "file1.ext")
+	os.WriteFile(filename, []byte("file contents"), 0644)
+	file, _ := os.Open(filename)
+	defer file.Close()
+
+	request := newStreamingUploader(t, file).httpRequest()
+	body, _ := io.ReadAll(request.Body)
+	if int64(len(body)) != request.ContentLength {
+		t.Errorf("Content-Length %d does not match the body length %d", request.ContentLength, len(body))
+	}
+	if request.GetBody == nil {
+		t.Fatalf("A seekable file should be rewindable")
+	}
+	again, ok := request.GetBody()
+	if ok != nil {
+		t.Fatalf("Unable to rewind the body: %s", ok)
+	}
+	if second, _ := io.ReadAll(again); !bytes.Equal(body, second) {
+		t.Errorf("The rewound body differs from the first")
+	}
+
+	request.Body, _ = request.GetBody()
+	if _, values := readFormFields(t, request); values["file"] != "file contents" {
+		t.Errorf("Unexpected file contents: %s", values["file"])
+	}
+}
+
+func TestUploadUnsizedReader(t *testing.T) {
+	file := io.MultiReader(strings.NewReader("file "), strings.NewReader("contents"))
+	request := newStreamingUploader(t, file).httpRequest()
+	if request.ContentLength <= int64(len("file contents")) {
+		t.Errorf("Content-Length should be known for a buffered reader: %d", request.ContentLength)
+	}
+	if _, values := readFormFields(t, request); values["file"] != "file contents" {
+		t.Errorf("Unexpected file contents: %s", values["file"])
+	}
+}
+
+func TestDegenerateUploadFileShorterThanSize(t *testing.T) {
+	file := &zeroFile{size: 1024}
+	request := newStreamingUploader(t, file).httpRequest()
+	file.size = 512
+	if _, ok := io.ReadAll(request.Body); !errors.Is(ok, io.ErrUnexpectedEOF) {
+		t.Errorf("A truncated file should fail the upload, got: %v", ok)
+	}
+}
+
+/*
+BenchmarkUploadBody shows the memory used to produce the request body does
+not grow with the size of the file.
+*/
+func BenchmarkUploadBody(b *testing.B) {
+	for _, size := range []int64{1 << 20, 64 << 20, 512 << 20} {
+		b.Run(fmt.Sprintf("%dMB", size>>20), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				request := newStreamingUploader(b, &zeroFile{size: size}).httpRequest()
+				if _, ok := io.Copy(io.Discard, request.Body); ok != nil {
+					b.Fatalf("Unable to read the body: %s", ok)
+				}
+			}
+		})
+	}
+}
@@ -2,31 +2,152 @@ package transport
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"errors"
+	"github.com/noahcampbell/s3dropbox/credentials"
 	"github.com/noahcampbell/s3dropbox/policy"
 	"io"
 	"net/http"
 	"net/url"
-	"mime/multipart"
+	"time"
 )
 
 type Uploader interface {
-	Upload()
+	Upload(ctx context.Context) (result *UploadResult, ok error)
 	httpRequest() (req *http.Request)
 }
 
 type httpUploader struct {
 	request *http.Request
+	body    *multipartBody
+	client  *http.Client
+	retry   RetryPolicy
+	bucket  string
+	key     string
 }
 
 type Options struct {
-	bucket string
-	key    string
-	policyEncoded []byte
-	signature []byte
+	bucket           string
+	key              string
+	awsAccessKeyId   string
+	policyEncoded    []byte
+	signature        []byte
+	client           *http.Client
+	credentials      credentials.Credentials
+	signatureVersion int
+	region           string
+	now              func() time.Time
+	presigned        []FormField
+	action           string
+	progress         ProgressFunc
+	retry            RetryPolicy
+	keyTemplate      string
+	endpoint         string
+	pathStyle        bool
+	dualStack        bool
+	accelerate       bool
+	contentType      string
 }
 
-func (h httpUploader) Upload() {
+/*
+Option configures an uploader created by NewSingleFileUploader.
+*/
+type Option func(o *Options)
+
+/*
+WithHTTPClient sends the upload with client instead of http.DefaultClient.
+*/
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *Options) {
+		o.client = client
+	}
+}
+
+/*
+WithCredentials signs the policy with c.  Without it credentials are taken
+from the environment or the shared credentials file.
+*/
+func WithCredentials(c credentials.Credentials) Option {
+	return func(o *Options) {
+		o.credentials = c
+	}
+}
+
+/*
+Upload sends the form to S3.  Any response other than a 2xx, or the 303 to
+success_action_redirect, is returned as an error, an *S3Error when S3
+describes the failure in the response body.  The redirect is not followed.
+Transient failures are retried according to the RetryPolicy when the file
+can be read again from the start.
+*/
+func (h httpUploader) Upload(ctx context.Context) (result *UploadResult, ok error) {
+	client := withoutSeeOther(h.client)
+	for attempt := 1; ; attempt++ {
+		request := h.request.WithContext(ctx)
+		if attempt > 1 {
+			if request.Body, ok = h.request.GetBody(); ok != nil {
+				return nil, ok
+			}
+		}
+		result, ok = h.send(client, request)
+		if ok == nil || attempt >= h.retry.MaxAttempts || h.request.GetBody == nil || !isRetryable(ok) {
+			return
+		}
+		if err := sleep(ctx, h.retry.delay(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (h httpUploader) send(client *http.Client, request *http.Request) (result *UploadResult, ok error) {
+	response, ok := client.Do(request)
+	if ok != nil {
+		return nil, ok
+	}
+	defer response.Body.Close()
+
+	if (response.StatusCode < 200 || response.StatusCode > 299) && response.StatusCode != http.StatusSeeOther {
+		return nil, newS3Error(response)
+	}
+	result, ok = newUploadResult(response, h.bucket, h.key)
+	io.Copy(io.Discard, response.Body)
+	if ok != nil {
+		return nil, ok
+	}
+	if h.body != nil {
+		result.Checksums, _ = h.body.Checksums()
+	}
+	if ok = verifyChecksums(result, response); ok != nil {
+		return nil, ok
+	}
+	return result, nil
+}
+
+/*
+withoutSeeOther copies client so it stops at the 303 S3 sends to
+success_action_redirect.  The redirect points at the uploader's page, not
+S3, and the result is read from it.  Other redirects are followed as client
+would.
+*/
+func withoutSeeOther(client *http.Client) *http.Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	copied := *client
+	check := client.CheckRedirect
+	copied.CheckRedirect = func(request *http.Request, via []*http.Request) error {
+		if request.Response != nil && request.Response.StatusCode == http.StatusSeeOther {
+			return http.ErrUseLastResponse
+		}
+		if check != nil {
+			return check(request, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("Stopped after 10 redirects.")
+		}
+		return nil
+	}
+	return &copied
 }
 
 func (h httpUploader) httpRequest() (req *http.Request) {
@@ -47,77 +168,119 @@ func (o *Options) getKeyFrom(p *policy.Policy) (found bool) {
 	return
 }
 
-func extractOptionsFromPolicy(policy *policy.Policy) (options *Options) {
-	options = &Options{}
-	options.getBucketFrom(policy)
-	options.getKeyFrom(policy)
+/*
+signedForm signs the policy with local credentials and derives the form
+fields from its conditions.
+*/
+func (o *Options) signedForm(p *policy.Policy, key string) (uploadURL *url.URL, fields []FormField, ok error) {
+	if o.credentials == nil {
+		o.credentials = credentials.NewChain("", "", "")
+	}
+	creds, ok := o.credentials.Retrieve()
+	if ok != nil {
+		return
+	}
+	if ok = o.signOptionsFromPolicy(p, creds); ok != nil {
+		return
+	}
+
+	if uploadURL, ok = o.bucketURL(); ok != nil {
+		return
+	}
+
+	fields = FieldsFromPolicy(p, key)
+	if creds.SessionToken != "" {
+		fields = setFormField(fields, "x-amz-security-token", creds.SessionToken)
+	}
+	fields = append(fields, o.signatureFields()...)
 	return
 }
 
-func (o *Options) signOptionsFromPolicy(signer *policy.Signer, policy *policy.Policy) (ok error) {
-	signer.AddPolicy(policy)
-	enc, sig, ok := signer.Sign()
-	if ok != nil {
-		return
+func extractOptionsFromPolicy(policy *policy.Policy, opts []Option) (options *Options) {
+	options = &Options{retry: DefaultRetryPolicy}
+	for _, opt := range opts {
+		opt(options)
 	}
-	o.policyEncoded = enc
-	o.signature = sig
+	options.getBucketFrom(policy)
+	options.getKeyFrom(policy)
 	return
 }
 
 /*
 NewSingleFileUploader creates a request formatted for AWS S3 Form Upload.
 Values, like bucketname and base path, are interpreted from the policy file.
+The file is streamed during Upload, so fileReader must remain open until
+then; a reader whose size can not be determined is read into memory.
 */
-func NewSingleFileUploader(policyReader io.Reader, filename string, fileReader io.Reader) (uploader Uploader, ok error) {
+func NewSingleFileUploader(policyReader io.Reader, filename string, fileReader io.Reader, opts ...Option) (uploader Uploader, ok error) {
 	prb := bytes.NewBuffer([]byte(""))
 	if _, ok := prb.ReadFrom(policyReader); ok != nil {
 		return nil, ok
 	}
 
-	var (
-		p *policy.Policy
-	  signer *policy.Signer
-	)
-
+	var p *policy.Policy
 	if p, ok = policy.ParsePolicy(prb.Bytes()); ok != nil {
 		return nil, ok
 	}
-	options := extractOptionsFromPolicy(p)
+	options := extractOptionsFromPolicy(p, opts)
 
-	signer, ok = policy.NewS3DropboxSigner("foobar", "barfoo")
+	var (
+		uploadURL *url.URL
+		key       string
+		fields    []FormField
+	)
+	switch {
+	case options.presigned != nil && options.keyTemplate != "":
+		ok = errors.New("A key template can not be used with a presigned form.")
+	case options.presigned != nil:
+		uploadURL, key, fields, ok = options.presignedForm(filename)
+	default:
+		if key, fileReader, ok = options.renderKey(p, filename, fileReader); ok == nil {
+			uploadURL, fields, ok = options.signedForm(p, key)
+		}
+	}
 	if ok != nil {
 		return nil, ok
 	}
 
-	options.signOptionsFromPolicy(signer, p)
-
-	uploadURL := &url.URL{Scheme: "https"}
-	uploadURL.Host = fmt.Sprintf("%s.s3.amazonaws.com", options.bucket)
-
-	keyURL, ok := url.Parse(options.key)
+	var contentType string
+	if options.presigned != nil {
+		contentType, fileReader, ok = options.presignedContentType(filename, fileReader)
+	} else {
+		contentType, fileReader, fields, ok = options.fileContentType(p, filename, fileReader, fields)
+		if ok == nil {
+			fields, fileReader, ok = checksumFields(p, fields, fileReader)
+		}
+	}
 	if ok != nil {
 		return nil, ok
 	}
 
-	filenameURL, ok := url.Parse(filename)
+	body, ok := newMultipartBody(fields, filename, contentType, fileReader)
 	if ok != nil {
 		return nil, ok
 	}
-
-	uploadURL = uploadURL.ResolveReference(keyURL)
-	uploadURL = uploadURL.ResolveReference(filenameURL)
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	fileWriter, _ := writer.CreateFormFile("file", filename)
-	if _, err := io.Copy(fileWriter, fileReader); err != nil {
-		return nil, err
+	if ok = evaluateFields(p, options.bucket, fields, body.size); ok != nil {
+		return nil, ok
 	}
-	writer.WriteField("signature", string(options.signature))
+	body.progress = options.progress
 
-	writer.Close()
-	request, ok := http.NewRequest("POST", uploadURL.String(), body)
-	request.Header.Set("Content-type", fmt.Sprintf("multipart/form-data; boundary=%s", writer.Boundary()))
-	uploader = &httpUploader{request: request}
+	request, ok := http.NewRequest("POST", uploadURL.String(), body.Reader())
+	if ok != nil {
+		return nil, ok
+	}
+	request.ContentLength = body.ContentLength()
+	if body.Rewindable() {
+		request.GetBody = body.Rewind
+	}
+	request.Header.Set("Content-type", body.ContentType())
+	uploader = &httpUploader{
+		request: request,
+		body:    body,
+		client:  options.client,
+		retry:   options.retry,
+		bucket:  options.bucket,
+		key:     key,
+	}
 	return
 }
@@ -0,0 +1,206 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+redirectTransport sends every request to the test server regardless of the
+S3 host name in the request URL.
+*/
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (r redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = r.target.Scheme
+	req.URL.Host = r.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) *http.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, _ := url.Parse(server.URL)
+	return &http.Client{Transport: redirectTransport{target}}
+}
+
+func newTestUploader(t *testing.T, client *http.Client) Uploader {
+	uploader, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"), WithHTTPClient(client), testCredentials, testContentType)
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	return uploader
+}
+
+func TestUploadSuccess(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"4a8ec4fa5f01b4ab1a0ab8cbccb709f0"`)
+		w.Header().Set("Location", "https://johnsmith.s3.amazonaws.com/user/eric/file1.ext")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	result, ok := newTestUploader(t, client).Upload(context.Background())
+	if ok != nil {
+		t.Fatalf("Upload failed: %s", ok)
+	}
+	expected := UploadResult{
+		StatusCode: http.StatusNoContent,
+		ETag:       "4a8ec4fa5f01b4ab1a0ab8cbccb709f0",
+		Location:   "https://johnsmith.s3.amazonaws.com/user/eric/file1.ext",
+		Bucket:     "johnsmith",
+		Key:        "user/eric/file1.ext",
+		Checksums:  file_contents_checksums,
+	}
+	if *result != expected {
+		t.Errorf("Unexpected result.  Expected:\n%+v\nActual:\n%+v", expected, *result)
+	}
+}
+
+func TestDegenerateUploadS3Error(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<Error>
+  <Code>AccessDenied</Code>
+  <Message>Invalid according to Policy: Policy expired.</Message>
+  <RequestId>4442587FB7D0A2F9</RequestId>
+  <HostId>Uuag1LuByRx9e6j5Onimru9pO4ZVKnJ2Qz7/C1NPcfTWAtRPfTaOFg==</HostId>
+</Error>`))
+	})
+
+	_, ok := newTestUploader(t, client).Upload(context.Background())
+	var s3err *S3Error
+	if !errors.As(ok, &s3err) {
+		t.Fatalf("Expected an *S3Error, got: %#v", ok)
+	}
+	expected := S3Error{
+		StatusCode: http.StatusForbidden,
+		Code:       "AccessDenied",
+		Message:    "Invalid according to Policy: Policy expired.",
+		RequestId:  "4442587FB7D0A2F9",
+		HostId:     "Uuag1LuByRx9e6j5Onimru9pO4ZVKnJ2Qz7/C1NPcfTWAtRPfTaOFg==",
+	}
+	if *s3err != expected {
+		t.Errorf("Unexpected error.  Expected:\n%+v\nActual:\n%+v", expected, *s3err)
+	}
+}
+
+func TestDegenerateUploadErrorWithoutBody(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amz-request-id", "REQ")
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	_, ok := newTestUploader(t, client).Upload(context.Background())
+	var s3err *S3Error
+	if !errors.As(ok, &s3err) {
+		t.Fatalf("Expected an *S3Error, got: %#v", ok)
+	}
+	if s3err.StatusCode != http.StatusBadGateway || s3err.RequestId != "REQ" {
+		t.Errorf("Status and request id not captured: %+v", s3err)
+	}
+}
+
+func TestDegenerateUploadCanceled(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := newTestUploader(t, client).Upload(ctx); !errors.Is(ok, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", ok)
+	}
+}
+
+const success_policy = `{"expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    {"%s": "%s"}
+  ]
+}`
+
+func TestUploadSuccessActionRedirect(t *testing.T) {
+	server := newS3TestServer(t)
+	result, ok := uploadTo(t, server, fmt.Sprintf(success_policy, "success_action_redirect", "http://johnsmith.s3.amazonaws.com/successful_upload.html"))
+	if ok != nil {
+		t.Fatalf("Upload failed: %s", ok)
+	}
+	checkStored(t, server, result)
+	if result.StatusCode != http.StatusSeeOther || !strings.HasPrefix(result.Location, "http://johnsmith.s3.amazonaws.com/successful_upload.html?") {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestUploadSuccessActionStatus(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusCreated, http.StatusNoContent} {
+		server := newS3TestServer(t)
+		result, ok := uploadTo(t, server, fmt.Sprintf(success_policy, "success_action_status", strconv.Itoa(status)))
+		if ok != nil {
+			t.Fatalf("Upload with success_action_status %d failed: %s", status, ok)
+		}
+		checkStored(t, server, result)
+		if result.StatusCode != status || result.Location != "https://johnsmith.s3.amazonaws.com/user/eric/file1.ext" {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+	}
+}
+
+func TestUploadResultFromS3(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"redirect": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				t.Errorf("The redirect to success_action_redirect should not be followed")
+			}
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set("Location", `http://example.com/done?bucket=johnsmith&key=user%2Feric%2Frenamed.ext&etag=%224a8ec4fa5f01b4ab1a0ab8cbccb709f0%22`)
+			w.WriteHeader(http.StatusSeeOther)
+		},
+		"PostResponse": func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<PostResponse>
+  <Location>http://example.com/done</Location>
+  <Bucket>johnsmith</Bucket>
+  <Key>user/eric/renamed.ext</Key>
+  <ETag>"4a8ec4fa5f01b4ab1a0ab8cbccb709f0"</ETag>
+</PostResponse>`)
+		},
+	} {
+		result, ok := newTestUploader(t, newTestClient(t, handler)).Upload(context.Background())
+		if ok != nil {
+			t.Fatalf("%s: Upload failed: %s", name, ok)
+		}
+		if result.Key != "user/eric/renamed.ext" || result.ETag != "4a8ec4fa5f01b4ab1a0ab8cbccb709f0" || !strings.HasPrefix(result.Location, "http://example.com/done") {
+			t.Errorf("%s: The key and ETag reported by S3 should be used: %+v", name, result)
+		}
+	}
+}
+
+func TestDegenerateUploadInvalidPostResponse(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "<PostResponse>")
+	})
+	if _, ok := newTestUploader(t, client).Upload(context.Background()); ok == nil || !strings.Contains(ok.Error(), "PostResponse") {
+		t.Errorf("Expected an invalid PostResponse to be reported, got: %v", ok)
+	}
+}
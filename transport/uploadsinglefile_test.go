@@ -10,6 +10,9 @@ import (
 	"mime/multipart"
 	"io"
 	"bytes"
+	"encoding/base64"
+
+	"github.com/noahcampbell/s3dropbox/credentials"
 )
 
 const (
@@ -27,9 +30,14 @@ const (
 `
 )
 
+var testCredentials = WithCredentials(credentials.NewStatic("foobar", "barfoo", ""))
+
+// UPLOAD_POLICY_EXAMPLE only accepts images; the test files are sent as one.
+var testContentType = WithContentType("image/jpeg")
+
 func TestDegenerateInvalidPolicy(t *testing.T) {
 	policyReader := strings.NewReader("{}")
-	if _, ok := NewSingleFileUploader(policyReader, "test.ext", nil); ok == nil {
+	if _, ok := NewSingleFileUploader(policyReader, "test.ext", nil, testCredentials, testContentType); ok == nil {
 		t.Fatalf("Expected NewSingleFileUploader to not return a correctly allocated reference.")
 	}
 }
@@ -80,7 +88,7 @@ func checkFileAddedToUpload(t *testing.T, request *http.Request, checks []func(t
 			ok, skipped := check(t, part)
 			if !skipped {
 				if !ok {
-					t.Fatalf("Failed check: %v", checks[i])
+					t.Fatalf("Failed check %d", i)
 					return
 				}
 				checksCovered[i] = true
@@ -93,7 +101,7 @@ func checkFileAddedToUpload(t *testing.T, request *http.Request, checks []func(t
 
 	for i, checked := range checksCovered {
 		if !checked {
-			t.Fatalf("Did not check: %#v", checks[i])
+			t.Fatalf("Did not run check %d", i)
 			return
 		}
 	}
@@ -134,12 +142,12 @@ func TestUploadFileConstructor(t *testing.T) {
 	policyReader := strings.NewReader(UPLOAD_POLICY_EXAMPLE)
 	fileToUpload := "file1.ext"
 	fileReader := strings.NewReader("file contents")
-	uploader, ok := NewSingleFileUploader(policyReader, fileToUpload, fileReader)
+	uploader, ok := NewSingleFileUploader(policyReader, fileToUpload, fileReader, testCredentials, testContentType)
 	if ok != nil {
 		t.Fatalf("Unable to create a SingleFileUploader")
 	}
 	checkHTTPMethodIsPost(t, uploader)
-	checkHTTPURLEquals(t, uploader, "https://johnsmith.s3.amazonaws.com/user/eric/file1.ext")
+	checkHTTPURLEquals(t, uploader, "https://johnsmith.s3.amazonaws.com/")
 	checkHTTPEnclosureType(t, uploader.httpRequest())
 	checks := []func(t *testing.T, part *multipart.Part) (bool, bool) {
 		checkPartFileExists,
@@ -147,3 +155,93 @@ func TestUploadFileConstructor(t *testing.T) {
 	}
 	checkFileAddedToUpload(t, uploader.httpRequest(), checks)
 }
+
+func readFormFields(t *testing.T, request *http.Request) (names []string, values map[string]string) {
+	_, params, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	reader := multipart.NewReader(request.Body, params["boundary"])
+	values = map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unable to read part: %s", err)
+		}
+		var pbody bytes.Buffer
+		pbody.ReadFrom(part)
+		names = append(names, part.FormName())
+		values[part.FormName()] = pbody.String()
+	}
+	return
+}
+
+func TestUploadFormFields(t *testing.T) {
+	policyReader := strings.NewReader(UPLOAD_POLICY_EXAMPLE)
+	uploader, ok := NewSingleFileUploader(policyReader, "file1.ext", strings.NewReader("file contents"), testCredentials, testContentType)
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	names, values := readFormFields(t, uploader.httpRequest())
+
+	expectedNames := []string{"key", "acl", "Content-Type", "success_action_redirect", "x-amz-meta-uuid", "x-amz-meta-tag", "AWSAccessKeyId", "policy", "signature", "file"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Fatalf("Form fields out of order.  Expected:\n%v\nActual:\n%v", expectedNames, names)
+	}
+
+	expectedValues := map[string]string{
+		"key":                     "user/eric/file1.ext",
+		"acl":                     "public-read",
+		"Content-Type":            "image/jpeg",
+		"success_action_redirect": "http://johnsmith.s3.amazonaws.com/successful_upload.html",
+		"x-amz-meta-uuid":         "14365123651274",
+		"x-amz-meta-tag":          "",
+		"AWSAccessKeyId":          "foobar",
+		"policy":                  base64.StdEncoding.EncodeToString([]byte(UPLOAD_POLICY_EXAMPLE)),
+		"signature":               "ljNZVWWNydBahCG5wWD64fTFEOU=",
+		"file":                    "file contents",
+	}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Errorf("Form field values incorrect.  Expected:\n%v\nActual:\n%v", expectedValues, values)
+	}
+}
+
+func TestDegenerateSessionTokenWithoutCondition(t *testing.T) {
+	policyReader := strings.NewReader(UPLOAD_POLICY_EXAMPLE)
+	temporary := WithCredentials(credentials.NewStatic("foobar", "barfoo", "token"))
+	if _, ok := NewSingleFileUploader(policyReader, "file1.ext", strings.NewReader("file contents"), temporary); ok == nil {
+		t.Errorf("Temporary credentials with a policy lacking x-amz-security-token should be an error")
+	}
+}
+
+func TestSessionToken(t *testing.T) {
+	policyReader := strings.NewReader(`{ "expiration": "2007-12-01T12:00:00.000Z",
+  "conditions": [
+    {"bucket": "johnsmith"},
+    ["starts-with", "$key", "user/eric/"],
+    {"x-amz-security-token": "token"}
+  ]
+}`)
+	temporary := WithCredentials(credentials.NewStatic("foobar", "barfoo", "token"))
+	uploader, ok := NewSingleFileUploader(policyReader, "file1.ext", strings.NewReader("file contents"), temporary)
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	if _, values := readFormFields(t, uploader.httpRequest()); values["x-amz-security-token"] != "token" {
+		t.Errorf("Session token not sent: %v", values)
+	}
+}
+
+func TestCredentialsFromEnvironment(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "envid")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "barfoo")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	uploader, ok := NewSingleFileUploader(strings.NewReader(UPLOAD_POLICY_EXAMPLE), "file1.ext", strings.NewReader("file contents"), testContentType)
+	if ok != nil {
+		t.Fatalf("Unable to create a SingleFileUploader: %s", ok)
+	}
+	_, values := readFormFields(t, uploader.httpRequest())
+	if values["AWSAccessKeyId"] != "envid" || values["signature"] != "ljNZVWWNydBahCG5wWD64fTFEOU=" {
+		t.Errorf("Environment credentials not used: %v", values)
+	}
+}